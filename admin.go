@@ -0,0 +1,283 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"context"
+	"time"
+	"github.com/7phs/coding-challenge-queserver/logger"
+)
+
+const (
+	ADMIN_SHUTDOWN_TIMEOUT = time.Second
+)
+
+type AdminQueue interface {
+	State() (int, int64)
+}
+
+type AdminRouter interface {
+	RegisteredUsers() []int64
+	Followers(int64) ([]int64, bool)
+	Following(int64) ([]int64, bool)
+	Mutuals(int64) ([]int64, bool)
+	FollowCounts(int64) (int, int, bool)
+	Blocked(int64) ([]int64, bool)
+	UserGroups(int64) ([]int64, bool)
+	Groups() []int64
+	GroupMembers(int64) ([]int64, bool)
+}
+
+// Admin serves JSON endpoints to look inside a running server
+type Admin struct {
+	addr string
+
+	listener   net.Listener
+	server     *http.Server
+	reloader   *Reloader
+	queue      AdminQueue
+	router     AdminRouter
+	statistics *Statistics
+
+	wait sync.WaitGroup
+}
+
+func NewAdmin(config *Config, reloader *Reloader, queue AdminQueue, router AdminRouter, statistics *Statistics) (*Admin, error) {
+	admin := &Admin{
+		addr:       config.Admin(),
+		reloader:   reloader,
+		queue:      queue,
+		router:     router,
+		statistics: statistics,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stats", admin.handleStats)
+	mux.HandleFunc("/queue", admin.handleQueue)
+	mux.HandleFunc("/users", admin.handleUsers)
+	mux.HandleFunc("/users/followers", admin.handleUserList("followers", router.Followers))
+	mux.HandleFunc("/users/following", admin.handleUserList("following", router.Following))
+	mux.HandleFunc("/users/mutuals", admin.handleUserList("mutuals", router.Mutuals))
+	mux.HandleFunc("/users/counts", admin.handleFollowCounts)
+	mux.HandleFunc("/users/blocked", admin.handleUserList("blocked", router.Blocked))
+	mux.HandleFunc("/users/groups", admin.handleUserList("groups", router.UserGroups))
+	mux.HandleFunc("/groups", admin.handleGroups)
+	mux.HandleFunc("/groups/members", admin.handleGroupMembers)
+	mux.HandleFunc("/config", admin.handleConfig)
+	mux.HandleFunc("/metrics", admin.handleMetrics)
+	mux.HandleFunc("/reload", admin.handleReload)
+	mux.HandleFunc("/log", admin.handleLog)
+
+	admin.server = &http.Server{Handler: mux}
+
+	return admin.Listen()
+}
+
+func (o *Admin) Listen() (s *Admin, err error) {
+	s = o
+
+	logger.Info("[ADMIN]: listen ", o.addr)
+
+	o.listener, err = net.Listen("tcp", o.addr)
+
+	return
+}
+
+func (o *Admin) Run() {
+	o.wait.Add(1)
+
+	go func() {
+		logger.Info("[ADMIN]: start working goroutin")
+
+		if err := o.server.Serve(o.listener); err != nil && err != http.ErrServerClosed {
+			logger.Error("[ADMIN]: error while serving requests: ", err)
+		}
+
+		logger.Info("[ADMIN]: shutdown working goroutin")
+		o.wait.Done()
+	}()
+}
+
+func (o *Admin) handleStats(w http.ResponseWriter, r *http.Request) {
+	o.reply(w, o.statistics.State())
+}
+
+func (o *Admin) handleQueue(w http.ResponseWriter, r *http.Request) {
+	depth, headId := o.queue.State()
+
+	o.reply(w, map[string]int64{
+		"depth":  int64(depth),
+		"headId": headId,
+	})
+}
+
+func (o *Admin) handleUsers(w http.ResponseWriter, r *http.Request) {
+	o.reply(w, map[string][]int64{
+		"registered": o.router.RegisteredUsers(),
+	})
+}
+
+// handleUserList replies ids of users or groups related to a user by the query parameter id
+func (o *Admin) handleUserList(name string, list func(int64) ([]int64, bool)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userId, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid user id", http.StatusBadRequest)
+			return
+		}
+
+		usersId, ok := list(userId)
+		if !ok {
+			http.Error(w, "unknown user", http.StatusNotFound)
+			return
+		}
+
+		o.reply(w, map[string]interface{}{
+			"id": userId,
+			name: usersId,
+		})
+	}
+}
+
+func (o *Admin) handleFollowCounts(w http.ResponseWriter, r *http.Request) {
+	userId, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid user id", http.StatusBadRequest)
+		return
+	}
+
+	followers, following, ok := o.router.FollowCounts(userId)
+	if !ok {
+		http.Error(w, "unknown user", http.StatusNotFound)
+		return
+	}
+
+	o.reply(w, map[string]int64{
+		"id":        userId,
+		"followers": int64(followers),
+		"following": int64(following),
+	})
+}
+
+func (o *Admin) handleGroups(w http.ResponseWriter, r *http.Request) {
+	o.reply(w, map[string][]int64{
+		"groups": o.router.Groups(),
+	})
+}
+
+func (o *Admin) handleGroupMembers(w http.ResponseWriter, r *http.Request) {
+	groupId, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid group id", http.StatusBadRequest)
+		return
+	}
+
+	members, ok := o.router.GroupMembers(groupId)
+	if !ok {
+		http.Error(w, "unknown group", http.StatusNotFound)
+		return
+	}
+
+	o.reply(w, map[string]interface{}{
+		"id":      groupId,
+		"members": members,
+	})
+}
+
+func (o *Admin) handleConfig(w http.ResponseWriter, r *http.Request) {
+	o.reply(w, o.reloader.Config().Dump())
+}
+
+func (o *Admin) handleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "use POST to reload a config", http.StatusMethodNotAllowed)
+		return
+	}
+
+	report, err := o.reloader.Reload()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	o.reply(w, report)
+}
+
+// handleLog replies with log levels, POST changes a level of a component, a user id or the global one.
+// An empty level removes an override.
+func (o *Admin) handleLog(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		query := r.URL.Query()
+
+		level := logger.ParseLevel(query.Get("level"), 0)
+		if level == 0 && (query.Get("level") != "" || query.Get("component") == "" && query.Get("user") == "") {
+			http.Error(w, "unknown log level", http.StatusBadRequest)
+			return
+		}
+
+		switch {
+		case query.Get("user") != "":
+			userId, err := strconv.ParseInt(query.Get("user"), 10, 64)
+			if err != nil {
+				http.Error(w, "invalid user id", http.StatusBadRequest)
+				return
+			}
+
+			if level == 0 {
+				logger.ClearUserLevel(userId)
+			} else {
+				logger.SetUserLevel(userId, level)
+			}
+
+		case query.Get("component") != "":
+			if level == 0 {
+				logger.ClearComponentLevel(query.Get("component"))
+			} else {
+				logger.SetComponentLevel(query.Get("component"), level)
+			}
+
+		default:
+			logger.SetFlags(level)
+		}
+
+		logger.Info("[ADMIN]: change log level ", r.URL.RawQuery)
+	}
+
+	components, users := logger.Overrides()
+
+	o.reply(w, map[string]string{
+		"level":      logger.LevelToString(logger.Flags()),
+		"components": FormatLevelOverrides(components),
+		"users":      FormatLevelOverrides(users),
+	})
+}
+
+func (o *Admin) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	WriteMetrics(w, o.statistics, o.queue, o.router)
+}
+
+func (o *Admin) reply(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Warning("[ADMIN]: failed to write a reply: ", err)
+	}
+}
+
+func (o *Admin) Shutdown() {
+	logger.Info("[ADMIN]: shutdown")
+
+	ctx, cancel := context.WithTimeout(context.Background(), ADMIN_SHUTDOWN_TIMEOUT)
+	defer cancel()
+
+	if err := o.server.Shutdown(ctx); err != nil {
+		logger.Warning("[ADMIN]: failed to shutdown gracefully: ", err)
+	}
+
+	o.wait.Wait()
+}
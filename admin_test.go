@@ -0,0 +1,174 @@
+package main
+
+import (
+	"net/http"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"github.com/7phs/coding-challenge-queserver/logger"
+)
+
+func TestAdmin(t *testing.T) {
+	config := &Config{
+		admin:        "127.0.0.1:0",
+		queueLimit:   1000,
+		clientBuffer: 16,
+	}
+	statistics := NewStatistics()
+	router := NewRouter(config, statistics)
+	queue := NewQueue(config, router, statistics)
+
+	router.RegisterClient(50, false)
+	router.PushMessage(NewMessage("1|F|60|50"))
+	router.PushMessage(NewMessage("2|F|70|50"))
+	router.PushMessage(NewMessage("3|GJ|60|9"))
+	queue.Accept(NewMessage("4|B"))
+	queue.Accept(NewMessage("3|B"))
+
+	reloader := NewReloader(config, func() (*Config, error) {
+		return &Config{
+			admin:        "127.0.0.1:0",
+			queueLimit:   500,
+			clientBuffer: 16,
+		}, nil
+	})
+
+	admin, err := NewAdmin(config, reloader, queue, router, statistics)
+	if err != nil {
+		t.Fatal("failed to init an admin server with error: ", err)
+	}
+	defer admin.Shutdown()
+
+	admin.Run()
+
+	url := "http://" + admin.listener.Addr().String()
+
+	get := func(path string, v interface{}) int {
+		resp, err := http.Get(url + path)
+		if err != nil {
+			t.Fatal("failed to request ", path, " with error: ", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+				t.Error("failed to decode a reply of ", path, " with error: ", err)
+			}
+		}
+
+		return resp.StatusCode
+	}
+
+	queueState := map[string]int64{}
+	get("/queue", &queueState)
+	if expected := map[string]int64{"depth": 2, "headId": 3}; !reflect.DeepEqual(queueState, expected) {
+		t.Error("failed to get a queue state. Got ", queueState, ", but expected is ", expected)
+	}
+
+	users := map[string][]int64{}
+	get("/users", &users)
+	if expected := []int64{50}; !reflect.DeepEqual(users["registered"], expected) {
+		t.Error("failed to get registered users. Got ", users["registered"], ", but expected is ", expected)
+	}
+
+	followers := struct {
+		Id        int64   `json:"id"`
+		Followers []int64 `json:"followers"`
+	}{}
+	get("/users/followers?id=50", &followers)
+	if expected := []int64{60, 70}; !reflect.DeepEqual(followers.Followers, expected) {
+		t.Error("failed to get followers. Got ", followers.Followers, ", but expected is ", expected)
+	}
+
+	following := struct {
+		Id        int64   `json:"id"`
+		Following []int64 `json:"following"`
+	}{}
+	get("/users/following?id=60", &following)
+	if expected := []int64{50}; !reflect.DeepEqual(following.Following, expected) {
+		t.Error("failed to get followed users. Got ", following.Following, ", but expected is ", expected)
+	}
+
+	counts := map[string]int64{}
+	get("/users/counts?id=50", &counts)
+	if expected := map[string]int64{"id": 50, "followers": 2, "following": 0}; !reflect.DeepEqual(counts, expected) {
+		t.Error("failed to get counts of followers. Got ", counts, ", but expected is ", expected)
+	}
+
+	groups := struct {
+		Id     int64   `json:"id"`
+		Groups []int64 `json:"groups"`
+	}{}
+	get("/users/groups?id=60", &groups)
+	if expected := []int64{9}; !reflect.DeepEqual(groups.Groups, expected) {
+		t.Error("failed to get groups of a user. Got ", groups.Groups, ", but expected is ", expected)
+	}
+
+	members := struct {
+		Id      int64   `json:"id"`
+		Members []int64 `json:"members"`
+	}{}
+	get("/groups/members?id=9", &members)
+	if expected := []int64{60}; !reflect.DeepEqual(members.Members, expected) {
+		t.Error("failed to get members of a group. Got ", members.Members, ", but expected is ", expected)
+	}
+
+	testSuites := []*struct {
+		path     string
+		expected int
+	}{
+		{path: "/users/followers?id=1000", expected: http.StatusNotFound},
+		{path: "/groups/members?id=1000", expected: http.StatusNotFound},
+		{path: "/groups", expected: http.StatusOK},
+		{path: "/users/followers?id=abc", expected: http.StatusBadRequest},
+		{path: "/users/mutuals?id=1000", expected: http.StatusNotFound},
+		{path: "/users/counts?id=abc", expected: http.StatusBadRequest},
+		{path: "/stats", expected: http.StatusOK},
+	}
+
+	for _, test := range testSuites {
+		if exist := get(test.path, &map[string]interface{}{}); exist != test.expected {
+			t.Error("failed to request ", test.path, ". Got ", exist, ", but expected is ", test.expected)
+		}
+	}
+
+	conf := map[string]interface{}{}
+	get("/config", &conf)
+	if exist := conf[CONFIG_QUEUE_LIMIT]; exist != float64(1000) {
+		t.Error("failed to get a config. Got ", exist, ", but expected is ", 1000)
+	}
+
+	resp, err := http.Post(url+"/reload", "", nil)
+	if err != nil {
+		t.Fatal("failed to request /reload with error: ", err)
+	}
+	defer resp.Body.Close()
+
+	report := ReloadReport{}
+	json.NewDecoder(resp.Body).Decode(&report)
+	if expected := []string{"QUEUE_LIMIT=1000->500"}; !reflect.DeepEqual(report.Applied, expected) {
+		t.Error("failed to reload a config. Got ", report.Applied, ", but expected is ", expected)
+	}
+
+	get("/config", &conf)
+	if exist := conf[CONFIG_QUEUE_LIMIT]; exist != float64(500) {
+		t.Error("failed to get a reloaded config. Got ", exist, ", but expected is ", 500)
+	}
+
+	resp, err = http.Post(url+"/log?component=client&level=debug", "", nil)
+	if err != nil {
+		t.Fatal("failed to request /log with error: ", err)
+	}
+	defer resp.Body.Close()
+	defer logger.SetOverrides(nil, nil)
+
+	levels := map[string]string{}
+	json.NewDecoder(resp.Body).Decode(&levels)
+	if exist := levels["components"]; exist != "CLIENT=Debug" {
+		t.Error("failed to override a log level. Got ", exist, ", but expected is ", "CLIENT=Debug")
+	}
+
+	if resp, err := http.Post(url+"/log?user=5&level=unknown", "", nil); err != nil || resp.StatusCode != http.StatusBadRequest {
+		t.Error("failed to reject an unknown log level. Got ", resp, " with error ", err)
+	}
+}
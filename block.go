@@ -0,0 +1,87 @@
+package main
+
+import (
+	"sort"
+	"github.com/7phs/coding-challenge-queserver/logger"
+)
+
+// a user blocks another one by "<sequenceId>|BL|<userId>|<blockedId>" and unblocks by "<sequenceId>|UB|<userId>|<blockedId>".
+// Follows, private messages and status updates of a blocked user are not delivered to the user.
+const (
+	MESSAGE_BLOCK MessageType = iota + MESSAGE_GROUP_MSG + 1
+	MESSAGE_UNBLOCK
+)
+
+func init() {
+	RegisterMessageType(MESSAGE_BLOCK, &MessageTypeInfo{
+		Code: "BL", Name: "Block", Layout: LAYOUT_FROM_TO, Handler: (*Router).handleBlock, State: true,
+	})
+	RegisterMessageType(MESSAGE_UNBLOCK, &MessageTypeInfo{
+		Code: "UB", Name: "Unblock", Layout: LAYOUT_FROM_TO, Handler: (*Router).handleUnblock, State: true,
+	})
+}
+
+func (o *UserInfo) Block(userId int64) {
+	o.blocked.Store(userId, true)
+}
+
+func (o *UserInfo) Unblock(userId int64) {
+	o.blocked.Delete(userId)
+}
+
+func (o *UserInfo) IsBlocked(userId int64) bool {
+	_, ok := o.blocked.Load(userId)
+
+	return ok
+}
+
+func (o *UserInfo) RangeBlocked(f func(key, value interface{}) bool) {
+	o.blocked.Range(f)
+}
+
+func (o *Router) handleBlock(msg *Message) {
+	userInfo := o.getOrAddUserInfo(msg.from)
+	if userInfo != nil {
+		userInfo.Block(msg.to)
+	}
+}
+
+func (o *Router) handleUnblock(msg *Message) {
+	userInfo := o.getOrAddUserInfo(msg.from)
+	if userInfo != nil {
+		userInfo.Unblock(msg.to)
+	}
+}
+
+// refuseBlocked checks a recipient of a message of a user, a refused message is counted
+func (o *Router) refuseBlocked(userInfo *UserInfo, msg *Message) bool {
+	if !userInfo.IsBlocked(msg.from) {
+		return false
+	}
+
+	logger.Debug("[ROUTER]: refuse message ", msg.payload, " of a blocked user -> ", logger.UserId(userInfo.userId))
+
+	o.statistics.AddBlocked(msg.typ)
+
+	return true
+}
+
+// Blocked returns ids of users blocked by a user ordered by id, an unknown user is reported by false
+func (o *Router) Blocked(userId int64) ([]int64, bool) {
+	userInfo, ok := o.loadUserInfo(userId)
+	if !ok {
+		return nil, false
+	}
+
+	result := []int64{}
+
+	userInfo.RangeBlocked(func(key, _ interface{}) bool {
+		result = append(result, key.(int64))
+
+		return true
+	})
+
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+
+	return result, true
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+	"reflect"
+)
+
+func TestRouter_Block(t *testing.T) {
+	statistics := NewStatistics()
+	router := NewRouter(&Config{clientBuffer: 16, mailboxSize: 16}, statistics)
+
+	chs := map[int64]<-chan *Message{}
+	for _, userId := range []int64{1, 2, 3} {
+		chs[userId] = router.RegisterClient(userId, false)
+	}
+
+	for _, payload := range []string{
+		"1|BL|1|2",
+		"2|F|2|1",
+		"3|P|2|1",
+		"4|F|1|2",
+		"5|F|3|2",
+		"6|S|2",
+		"7|UB|1|2",
+		"8|P|2|1",
+	} {
+		router.PushMessage(NewMessage(payload))
+	}
+
+	expected := map[int64][]string{
+		1: {"8|P|2|1"},
+		2: {"4|F|1|2", "5|F|3|2"},
+		3: {"6|S|2"},
+	}
+
+	for userId, ch := range chs {
+		exist := []string{}
+		for len(ch) > 0 {
+			exist = append(exist, (<-ch).payload)
+		}
+
+		if !reflect.DeepEqual(exist, expected[userId]) {
+			t.Error("failed to route messages of blocked users to #", userId, ". Got ", exist, ", but expected is ", expected[userId])
+		}
+	}
+
+	if exist, _ := router.Followers(1); len(exist) != 0 {
+		t.Error("failed to refuse a follow of a blocked user. Got followers ", exist)
+	}
+
+	if exist, ok := router.Blocked(1); !ok || len(exist) != 0 {
+		t.Error("failed to unblock a user. Got ", exist)
+	}
+
+	for _, messageType := range []MessageType{MESSAGE_FOLLOW, MESSAGE_PRIVATE_MSG, MESSAGE_STATUS_UPDATE} {
+		if exist := statistics.blocked[messageType]; exist != 1 {
+			t.Error("failed to count refused messages of ", messageType, ". Got ", exist, ", but expected is ", 1)
+		}
+	}
+}
@@ -4,16 +4,33 @@ import (
 	"net"
 	"bufio"
 	"strconv"
+	"strings"
+	"errors"
+	"time"
 	"github.com/7phs/coding-challenge-queserver/logger"
 )
 
+var (
+	invalidHandshakeErr = errors.New("invalid handshake")
+)
+
+const (
+	// a client opts in acknowledgements by the handshake "<userId> ACK",
+	// a reconnecting client asks for missed messages by the handshake "<userId> <lastSeenSequenceId>"
+	CLIENT_ACK_COMMAND = "ACK"
+)
+
 type Client struct {
 	conn       net.Conn
+	reader     *bufio.Reader
 	router     EventRouter
 	statistics *Statistics
 	err        error
 
-	userId   int64
+	userId     int64
+	ack        bool
+	resume     bool
+	lastSeenId int64
 	ch       <-chan *Message
 	shutdown chan struct{}
 }
@@ -43,7 +60,9 @@ func (o *Client) Handshake() (c *Client) {
 
 	logger.Debug("[CLIENT]: handshaking, start")
 
-	line, _, err := bufio.NewReader(o.conn).ReadLine()
+	o.reader = bufio.NewReader(o.conn)
+
+	line, _, err := o.reader.ReadLine()
 	if err != nil {
 		logger.Warning("[CLIENT]: handshaking, error while read line with id: ", err)
 
@@ -51,7 +70,15 @@ func (o *Client) Handshake() (c *Client) {
 		return
 	}
 
-	o.userId, err = strconv.ParseInt(string(line), 10, 64)
+	parts := strings.Fields(string(line))
+	if len(parts) == 0 {
+		logger.Warning("[CLIENT]: handshaking, empty line")
+
+		o.err = invalidHandshakeErr
+		return
+	}
+
+	o.userId, err = strconv.ParseInt(parts[0], 10, 64)
 	if err != nil {
 		logger.Warning("[CLIENT]: handshaking, error while parse user id: ", err)
 
@@ -59,7 +86,24 @@ func (o *Client) Handshake() (c *Client) {
 		return
 	}
 
-	logger.Debug("[CLIENT]: handshaking, got user id #", o.userId)
+	for _, option := range parts[1:] {
+		switch option {
+		case CLIENT_ACK_COMMAND:
+			o.ack = true
+		default:
+			if o.lastSeenId, err = strconv.ParseInt(option, 10, 64); err != nil || o.resume {
+				logger.Warning("[CLIENT]: handshaking, unknown option '", option, "'")
+
+				o.err = invalidHandshakeErr
+				return
+			}
+
+			o.resume = true
+		}
+	}
+
+	logger.Debug("[CLIENT]: handshaking, got user id #", logger.UserId(o.userId), ", acknowledgements ", o.ack,
+		", resume ", o.resume, " after #", o.lastSeenId)
 
 	return
 }
@@ -68,40 +112,58 @@ func (o *Client) Register() (c *Client) {
 	c = o
 
 	if o.HasError() != nil {
-		logger.Debug("[CLIENT]: register #", o.userId, ", skip for error")
+		logger.Debug("[CLIENT]: register #", logger.UserId(o.userId), ", skip for error")
 
 		return
 	}
 
-	logger.Debug("[CLIENT]: register #", o.userId)
+	logger.Debug("[CLIENT]: register #", logger.UserId(o.userId))
+
+	if o.resume {
+		o.router.ResumeClient(o.userId, o.lastSeenId)
+	}
 
-	o.ch = o.router.RegisterClient(o.userId)
+	o.ch = o.router.RegisterClient(o.userId, o.ack)
 
 	return
 }
 
 func (o *Client) Run() {
 	if o.HasError() != nil {
-		logger.Debug("[CLIENT]: #", o.userId, " run, skip for error")
+		logger.Debug("[CLIENT]: #", logger.UserId(o.userId), " run, skip for error")
 		return
 	}
 
+	if o.ack {
+		go o.readAcks()
+	}
+
 	go func() {
 		work := true
 
-		logger.Debug("[CLIENT]: #", o.userId, ", start working goroutin")
+		logger.Debug("[CLIENT]: #", logger.UserId(o.userId), ", start working goroutin")
 
 		for work {
 			select {
-			case msg := <-o.ch:
+			case msg, ok := <-o.ch:
+				if !ok {
+					// the router has disconnected the client as a slow consumer or a newer connection
+					logger.Debug("[CLIENT]: #", logger.UserId(o.userId), ", disconnected by the router")
+
+					work = false
+					break
+				}
+
 				o.statistics.Add(MESSAGE_SEND, msg.typ)
 
 				n, err := o.conn.Write([]byte(msg.payload + "\r\n"))
 				if err != nil {
-					logger.Warning("[CLIENT]: #", o.userId, ", got error while write data: ", err)
+					logger.Warning("[CLIENT]: #", logger.UserId(o.userId), ", got error while write data: ", err)
 
 					o.Unregister()
 					work = false
+				} else {
+					o.statistics.ObserveLatency(msg, time.Now())
 				}
 
 				logger.Debug("[CLIENT]: write '", msg.payload, "':", n)
@@ -111,14 +173,41 @@ func (o *Client) Run() {
 			}
 		}
 
-		logger.Debug("[CLIENT]: #", o.userId, ", stop working goroutin and close connection")
+		logger.Debug("[CLIENT]: #", logger.UserId(o.userId), ", stop working goroutin and close connection")
 
 		o.conn.Close()
 	}()
 }
 
+// readAcks passes lines "ACK <sequenceId>" of the last processed message to the router
+func (o *Client) readAcks() {
+	for {
+		line, _, err := o.reader.ReadLine()
+		if err != nil {
+			logger.Debug("[CLIENT]: #", logger.UserId(o.userId), ", stop reading acknowledgements: ", err)
+			return
+		}
+
+		parts := strings.Fields(string(line))
+		if len(parts) != 2 || parts[0] != CLIENT_ACK_COMMAND {
+			logger.Warning("[CLIENT]: #", logger.UserId(o.userId), ", unknown command '", string(line), "'")
+			continue
+		}
+
+		sequenceId, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			logger.Warning("[CLIENT]: #", logger.UserId(o.userId), ", error while parse acknowledged sequence id: ", err)
+			continue
+		}
+
+		logger.Debug("[CLIENT]: #", logger.UserId(o.userId), ", acknowledged ", logger.SequenceId(sequenceId))
+
+		o.router.AckClient(o.userId, sequenceId)
+	}
+}
+
 func (o *Client) Unregister() {
-	logger.Debug("[CLIENT]: unregister #", o.userId)
+	logger.Debug("[CLIENT]: unregister #", logger.UserId(o.userId))
 
-	o.router.UnregisterClient(o.userId)
+	o.router.UnregisterClient(o.userId, o.ch)
 }
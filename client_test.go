@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"sync"
 	"bufio"
+	"time"
 )
 
 const (
@@ -19,12 +20,16 @@ type TestClientRouter struct {
 	ch chan *Message
 
 	userId     int64
+	ack        bool
+	lastSeenId int64
+	acked      chan int64
 	registered int
 }
 
 func NewTestClientRouter() *TestClientRouter {
 	return &TestClientRouter{
 		ch:         make(chan *Message),
+		acked:      make(chan int64, 16),
 		registered: TEST_REGISTER_INIT,
 	}
 }
@@ -33,14 +38,35 @@ func (o *TestClientRouter) PushMessage(msg *Message) {
 	o.ch <- msg
 }
 
-func (o *TestClientRouter) RegisterClient(userId int64) <-chan *Message {
+func (o *TestClientRouter) Accept(msg *Message) error {
+	o.PushMessage(msg)
+
+	return nil
+}
+
+func (o *TestClientRouter) Sync() error {
+	return nil
+}
+
+func (o *TestClientRouter) RegisterClient(userId int64, ack bool) <-chan *Message {
 	o.userId = userId
+	o.ack = ack
 	o.registered = TEST_REGISTER_REGISTERED
 
 	return o.ch
 }
 
-func (o *TestClientRouter) UnregisterClient(userId int64) {
+func (o *TestClientRouter) AckClient(userId int64, sequenceId int64) {
+	if o.userId == userId {
+		o.acked <- sequenceId
+	}
+}
+
+func (o *TestClientRouter) ResumeClient(userId int64, lastSeenId int64) {
+	o.lastSeenId = lastSeenId
+}
+
+func (o *TestClientRouter) UnregisterClient(userId int64, _ <-chan *Message) {
 	if o.userId == userId {
 		o.registered = TEST_REGISTER_UNREGISTERED
 	}
@@ -201,3 +227,93 @@ func TestNewClient_Err(t *testing.T) {
 
 	close(shutdown)
 }
+
+func TestNewClient_Ack(t *testing.T) {
+	randPort := fmt.Sprintf(":%d", 16000+rand.Intn(60000-16000))
+	userId := int64(1000 + rand.Intn(60000))
+
+	listener, err := net.Listen("tcp", randPort)
+	if err != nil {
+		t.Error("failed to start listening client ", randPort, " with error ", err)
+	}
+	defer listener.Close()
+
+	testRouter := NewTestClientRouter()
+	shutdown := make(chan struct{})
+	defer close(shutdown)
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		NewClient(conn, testRouter, NewStatistics(), shutdown).Run()
+	}()
+
+	connection, err := net.Dial("tcp", randPort)
+	if err != nil {
+		t.Error("failed to connect as a client to ", randPort, " with error: ", err)
+		return
+	}
+	defer connection.Close()
+
+	// an acknowledgement goes right after the handshake in the same packet
+	connection.Write([]byte(fmt.Sprintf("%d ACK\r\nACK 666\r\n", userId)))
+
+	select {
+	case exist := <-testRouter.acked:
+		if exist != 666 {
+			t.Error("failed to pass an acknowledgement. Got ", exist, ", but expected is ", 666)
+		}
+	case <-time.After(time.Second):
+		t.Error("failed to receive an acknowledgement")
+	}
+
+	if !testRouter.ack {
+		t.Error("failed to register a client in the acknowledgement mode")
+	}
+}
+
+func TestClient_Handshake(t *testing.T) {
+	testSuites := []*struct {
+		line             string
+		expectedId       int64
+		expectedAck      bool
+		expectedLastSeen int64
+		expectedErr      bool
+	}{
+		{line: "123", expectedId: 123},
+		{line: "123 ACK", expectedId: 123, expectedAck: true},
+		{line: "123 456", expectedId: 123, expectedLastSeen: 456},
+		{line: "123 456 ACK", expectedId: 123, expectedAck: true, expectedLastSeen: 456},
+		{line: "123 456 789", expectedErr: true},
+		{line: "123 UNKNOWN", expectedErr: true},
+		{line: "", expectedErr: true},
+		{line: "abc", expectedErr: true},
+	}
+
+	for _, test := range testSuites {
+		server, client := net.Pipe()
+
+		go func() {
+			client.Write([]byte(test.line + "\r\n"))
+		}()
+
+		exist := (&Client{conn: server}).Handshake()
+
+		if test.expectedErr {
+			if exist.HasError() == nil {
+				t.Error("failed to catch an error of a handshake '", test.line, "'")
+			}
+		} else if exist.HasError() != nil {
+			t.Error("failed to handshake '", test.line, "' with error ", exist.HasError())
+		} else if exist.userId != test.expectedId || exist.ack != test.expectedAck || exist.lastSeenId != test.expectedLastSeen {
+			t.Error("failed to handshake '", test.line, "'. Got ", exist.userId, "/", exist.ack, "/", exist.lastSeenId,
+				", but expected is ", test.expectedId, "/", test.expectedAck, "/", test.expectedLastSeen)
+		}
+
+		server.Close()
+		client.Close()
+	}
+}
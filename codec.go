@@ -0,0 +1,194 @@
+package main
+
+import (
+	"io"
+	"bytes"
+	"bufio"
+	"errors"
+	"strings"
+	"encoding/json"
+	"encoding/binary"
+)
+
+const (
+	CODEC_PIPE   = "pipe"
+	CODEC_JSON   = "json"
+	CODEC_BINARY = "binary"
+
+	// a frame of the binary codec: a big-endian uint32 length and a body of sequenceId, from, to and a type code
+	CODEC_BINARY_HEADER_SIZE = 8 + 8 + 8
+	CODEC_BINARY_FRAME_LIMIT = 64 * 1024
+)
+
+var (
+	frameTooLargeErr = errors.New("frame is too large")
+)
+
+// EventCodec reads messages of event sources in a wire format
+type EventCodec interface {
+	Name() string
+	// ReadFrame reads a raw frame of a message, an error stops reading a connection
+	ReadFrame(*bufio.Reader) ([]byte, error)
+	// Decode parses a frame. A malformed frame is reported by an error with a message keeping a parsed sequenceId.
+	Decode([]byte) (*Message, error)
+}
+
+func NewEventCodec(name string) (EventCodec, error) {
+	switch strings.ToLower(name) {
+	case CODEC_PIPE:
+		return &PipeCodec{}, nil
+	case CODEC_JSON:
+		return &JsonCodec{}, nil
+	case CODEC_BINARY:
+		return &BinaryCodec{}, nil
+	default:
+		return nil, errors.New("unknown codec " + name)
+	}
+}
+
+// newDecodedMessage makes a message with a payload in the pipe-delimited format sent to clients
+func newDecodedMessage(sequenceId int64, typ MessageType, from, to int64) (*Message, error) {
+	msg := NewMessage(FormatPayload(sequenceId, typ, from, to))
+	if typ == MESSAGE_UNKNOWN {
+		msg.sequenceId = sequenceId
+		msg.err = invalidMessageErr
+	}
+
+	return msg, msg.HasError()
+}
+
+func readLineFrame(reader *bufio.Reader) ([]byte, error) {
+	line, _, err := reader.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+
+	// a buffer of the reader is reused by the next reading
+	return append([]byte(nil), line...), nil
+}
+
+// PipeCodec reads lines "sequenceId|type|from|to"
+type PipeCodec struct{}
+
+func (o *PipeCodec) Name() string {
+	return CODEC_PIPE
+}
+
+func (o *PipeCodec) ReadFrame(reader *bufio.Reader) ([]byte, error) {
+	return readLineFrame(reader)
+}
+
+func (o *PipeCodec) Decode(frame []byte) (*Message, error) {
+	msg := NewMessage(string(frame))
+
+	return msg, msg.HasError()
+}
+
+// JsonCodec reads lines {"sequenceId": 1, "type": "F", "from": 2, "to": 3}
+type JsonCodec struct{}
+
+type jsonEvent struct {
+	SequenceId int64  `json:"sequenceId"`
+	Type       string `json:"type"`
+	From       *int64 `json:"from"`
+	To         *int64 `json:"to"`
+}
+
+func (o *JsonCodec) Name() string {
+	return CODEC_JSON
+}
+
+func (o *JsonCodec) ReadFrame(reader *bufio.Reader) ([]byte, error) {
+	return readLineFrame(reader)
+}
+
+func (o *JsonCodec) Decode(frame []byte) (*Message, error) {
+	event := jsonEvent{}
+
+	decoder := json.NewDecoder(bytes.NewReader(frame))
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(&event); err != nil {
+		return &Message{typ: MESSAGE_UNKNOWN, err: err}, err
+	}
+
+	typ := FromString(event.Type)
+
+	info := typ.Info()
+	if info == nil {
+		return &Message{sequenceId: event.SequenceId, typ: typ, err: invalidMessageErr}, invalidMessageErr
+	}
+
+	var from, to int64
+
+	if (info.Layout.Fields() > 0) != (event.From != nil) || (info.Layout.Fields() > 1) != (event.To != nil) {
+		return &Message{sequenceId: event.SequenceId, typ: typ, err: invalidMessageErr}, invalidMessageErr
+	}
+
+	if event.From != nil {
+		from = *event.From
+	}
+	if event.To != nil {
+		to = *event.To
+	}
+
+	return newDecodedMessage(event.SequenceId, typ, from, to)
+}
+
+// BinaryCodec reads frames of a big-endian uint32 length and a body of a big-endian int64 sequenceId,
+// int64 from, int64 to and bytes of a type code up to the end of the frame. Unused fields of a type are zero.
+type BinaryCodec struct{}
+
+func (o *BinaryCodec) Name() string {
+	return CODEC_BINARY
+}
+
+func (o *BinaryCodec) ReadFrame(reader *bufio.Reader) ([]byte, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, err
+	}
+
+	size := binary.BigEndian.Uint32(header)
+	if size > CODEC_BINARY_FRAME_LIMIT {
+		return nil, frameTooLargeErr
+	}
+
+	frame := make([]byte, size)
+	if _, err := io.ReadFull(reader, frame); err != nil {
+		return nil, err
+	}
+
+	return frame, nil
+}
+
+func (o *BinaryCodec) Decode(frame []byte) (*Message, error) {
+	if len(frame) <= CODEC_BINARY_HEADER_SIZE {
+		msg := &Message{typ: MESSAGE_UNKNOWN, err: invalidMessageErr}
+		if len(frame) >= 8 {
+			msg.sequenceId = int64(binary.BigEndian.Uint64(frame))
+		}
+
+		return msg, invalidMessageErr
+	}
+
+	return newDecodedMessage(
+		int64(binary.BigEndian.Uint64(frame)),
+		FromString(string(frame[CODEC_BINARY_HEADER_SIZE:])),
+		int64(binary.BigEndian.Uint64(frame[8:])),
+		int64(binary.BigEndian.Uint64(frame[16:])))
+}
+
+// EncodeBinaryFrame writes a message in the format of the binary codec
+func EncodeBinaryFrame(sequenceId int64, typ MessageType, from, to int64) []byte {
+	code := typ.Code()
+	frame := make([]byte, 4+CODEC_BINARY_HEADER_SIZE+len(code))
+
+	binary.BigEndian.PutUint32(frame, uint32(CODEC_BINARY_HEADER_SIZE+len(code)))
+	binary.BigEndian.PutUint64(frame[4:], uint64(sequenceId))
+	binary.BigEndian.PutUint64(frame[12:], uint64(from))
+	binary.BigEndian.PutUint64(frame[20:], uint64(to))
+	copy(frame[4+CODEC_BINARY_HEADER_SIZE:], code)
+
+	return frame
+}
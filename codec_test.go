@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"bufio"
+	"testing"
+)
+
+func TestEventCodec_Decode(t *testing.T) {
+	testSuites := []*struct {
+		codec       EventCodec
+		frame       []byte
+		expected    string
+		expectedSeq int64
+		expectedErr bool
+	}{
+		{codec: &PipeCodec{}, frame: []byte("666|F|60|50"), expected: "666|F|60|50", expectedSeq: 666},
+		{codec: &PipeCodec{}, frame: []byte("634"), expectedSeq: 634, expectedErr: true},
+		{codec: &JsonCodec{}, frame: []byte(`{"sequenceId":666,"type":"F","from":60,"to":50}`), expected: "666|F|60|50", expectedSeq: 666},
+		{codec: &JsonCodec{}, frame: []byte(`{"sequenceId":634,"type":"S","from":32}`), expected: "634|S|32", expectedSeq: 634},
+		{codec: &JsonCodec{}, frame: []byte(`{"sequenceId":542532,"type":"B"}`), expected: "542532|B", expectedSeq: 542532},
+		{codec: &JsonCodec{}, frame: []byte(`{"sequenceId":43,"type":"P","from":32}`), expectedSeq: 43, expectedErr: true},
+		{codec: &JsonCodec{}, frame: []byte(`{"sequenceId":43,"type":"B","to":32}`), expectedSeq: 43, expectedErr: true},
+		{codec: &JsonCodec{}, frame: []byte(`{"sequenceId":43,"type":"J"}`), expectedSeq: 43, expectedErr: true},
+		{codec: &JsonCodec{}, frame: []byte(`{"sequenceId":43,"type":"B","group":1}`), expectedErr: true},
+		{codec: &JsonCodec{}, frame: []byte(`43|B`), expectedErr: true},
+		{codec: &BinaryCodec{}, frame: EncodeBinaryFrame(666, MESSAGE_FOLLOW, 60, 50)[4:], expected: "666|F|60|50", expectedSeq: 666},
+		{codec: &BinaryCodec{}, frame: EncodeBinaryFrame(634, MESSAGE_STATUS_UPDATE, 32, 0)[4:], expected: "634|S|32", expectedSeq: 634},
+		{codec: &BinaryCodec{}, frame: EncodeBinaryFrame(43, MESSAGE_UNKNOWN, 32, 56)[4:], expectedSeq: 43, expectedErr: true},
+		{codec: &BinaryCodec{}, frame: EncodeBinaryFrame(43, MESSAGE_BROADCAST, 0, 0)[4:12], expectedSeq: 43, expectedErr: true},
+		{codec: &BinaryCodec{}, frame: []byte{1, 2}, expectedErr: true},
+	}
+
+	for _, test := range testSuites {
+		msg, err := test.codec.Decode(test.frame)
+
+		if msg.sequenceId != test.expectedSeq {
+			t.Error("failed to decode a sequenceId of ", test.codec.Name(), " '", string(test.frame), "'. Got ", msg.sequenceId, ", but expected is ", test.expectedSeq)
+		}
+
+		if test.expectedErr {
+			if err == nil {
+				t.Error("failed to catch an error of ", test.codec.Name(), " '", string(test.frame), "'")
+			}
+		} else if err != nil {
+			t.Error("failed to decode ", test.codec.Name(), " '", string(test.frame), "' with error ", err)
+		} else if msg.payload != test.expected || !msg.IsValid() {
+			t.Error("failed to decode ", test.codec.Name(), " '", string(test.frame), "'. Got ", msg.payload, ", but expected is ", test.expected)
+		}
+	}
+}
+
+func TestBinaryCodec_ReadFrame(t *testing.T) {
+	codec := &BinaryCodec{}
+
+	flow := append(EncodeBinaryFrame(1, MESSAGE_BROADCAST, 0, 0), EncodeBinaryFrame(2, MESSAGE_PRIVATE_MSG, 3, 4)...)
+	reader := bufio.NewReader(bytes.NewReader(flow))
+
+	for _, expected := range []string{"1|B", "2|P|3|4"} {
+		frame, err := codec.ReadFrame(reader)
+		if err != nil {
+			t.Error("failed to read a frame with error ", err)
+			return
+		}
+
+		if msg, _ := codec.Decode(frame); msg.payload != expected {
+			t.Error("failed to read a frame. Got ", msg.payload, ", but expected is ", expected)
+		}
+	}
+
+	if _, err := codec.ReadFrame(reader); err == nil {
+		t.Error("failed to catch the end of a flow")
+	}
+
+	tooLarge := bufio.NewReader(bytes.NewReader([]byte{0xff, 0xff, 0xff, 0xff}))
+	if _, err := codec.ReadFrame(tooLarge); err != frameTooLargeErr {
+		t.Error("failed to reject a large frame. Got ", err, ", but expected is ", frameTooLargeErr)
+	}
+}
+
+func TestNewEventCodec(t *testing.T) {
+	for _, name := range []string{CODEC_PIPE, CODEC_JSON, CODEC_BINARY} {
+		codec, err := NewEventCodec(name)
+		if err != nil {
+			t.Error("failed to create a codec ", name, " with error ", err)
+		} else if codec.Name() != name {
+			t.Error("failed to create a codec. Got ", codec.Name(), ", but expected is ", name)
+		}
+	}
+
+	if _, err := NewEventCodec("xml"); err == nil {
+		t.Error("failed to catch an unknown codec")
+	}
+}
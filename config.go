@@ -2,7 +2,12 @@ package main
 
 import (
 	"os"
+	"io"
+	"fmt"
+	"flag"
 	"errors"
+	"strconv"
+	"strings"
 	"time"
 	"github.com/7phs/coding-challenge-queserver/logger"
 )
@@ -13,12 +18,98 @@ const (
 	DEFAULT_QUEUE_LIMIT    = 1000
 	DEFAULT_QUEUE_TTL      = 500 // milliseconds
 	DEFAULT_LOG_LEVEL      = logger.INFO
+	DEFAULT_WAL_DIR           = "" // disabled
+	DEFAULT_WAL_SEGMENT_SIZE  = 64 * 1024 * 1024 // bytes
+	DEFAULT_WAL_SYNC          = WAL_SYNC_INTERVAL
+	DEFAULT_WAL_SYNC_INTERVAL = 1000 // milliseconds
+	DEFAULT_SNAPSHOT_PATH     = "" // disabled
+	DEFAULT_SNAPSHOT_INTERVAL = 60000 // milliseconds
+	DEFAULT_MAILBOX_SIZE      = 100
+	DEFAULT_MAILBOX_TTL       = 60000 // milliseconds
+	DEFAULT_HISTORY_SIZE      = 100
+	DEFAULT_HISTORY_TTL       = 60000 // milliseconds
+	DEFAULT_CLIENT_BUFFER     = 1024
+	DEFAULT_CLIENT_OVERFLOW   = OVERFLOW_DROP_OLDEST
+	DEFAULT_CLIENT_UNACKED    = 1000
+	DEFAULT_QUEUE_MODE        = QUEUE_MODE_LIMIT
+	DEFAULT_QUEUE_GAP_TIMEOUT = 1000 // milliseconds
+	DEFAULT_DEDUP_WINDOW      = 10000
+	DEFAULT_DEDUP_BY_SOURCE   = false
+	DEFAULT_ADMIN             = "" // disabled
+	DEFAULT_SHUTDOWN_TIMEOUT  = 5000 // milliseconds
+	DEFAULT_LOG_FORMAT        = logger.FORMAT_TEXT
+	DEFAULT_LOG_COMPONENTS    = ""
+	DEFAULT_LOG_USERS         = ""
+	DEFAULT_LOG_SAMPLE_FIRST  = 0 // disabled
+	DEFAULT_LOG_SAMPLE_EVERY  = 100
+	DEFAULT_EVENT_SOURCE_JSON   = "" // disabled
+	DEFAULT_EVENT_SOURCE_BINARY = "" // disabled
+	DEFAULT_ROUTER_SHARDS       = 0
+	DEFAULT_ROUTER_FANOUT_WORKERS   = 4
+	DEFAULT_ROUTER_FANOUT_THRESHOLD = 1000
 
 	CONFIG_EVENT_SOURCE = "EVENT_SOURCE"
 	CONFIG_CLIENT       = "CLIENT"
 	CONFIG_QUEUE_LIMIT  = "QUEUE_LIMIT"
 	CONFIG_QUEUE_TTL    = "QUEUE_TTL"
 	CONFIG_LOG_LEVEL    = "LOG_LEVEL"
+	CONFIG_WAL_DIR           = "WAL_DIR"
+	CONFIG_WAL_SEGMENT_SIZE  = "WAL_SEGMENT_SIZE"
+	CONFIG_WAL_SYNC          = "WAL_SYNC"
+	CONFIG_WAL_SYNC_INTERVAL = "WAL_SYNC_INTERVAL"
+	CONFIG_SNAPSHOT_PATH     = "SNAPSHOT_PATH"
+	CONFIG_SNAPSHOT_INTERVAL = "SNAPSHOT_INTERVAL"
+	CONFIG_MAILBOX_SIZE      = "MAILBOX_SIZE"
+	CONFIG_MAILBOX_TTL       = "MAILBOX_TTL"
+	CONFIG_HISTORY_SIZE      = "HISTORY_SIZE"
+	CONFIG_HISTORY_TTL       = "HISTORY_TTL"
+	CONFIG_CLIENT_BUFFER     = "CLIENT_BUFFER"
+	CONFIG_CLIENT_OVERFLOW   = "CLIENT_OVERFLOW"
+	CONFIG_CLIENT_UNACKED    = "CLIENT_UNACKED"
+	CONFIG_QUEUE_MODE        = "QUEUE_MODE"
+	CONFIG_QUEUE_GAP_TIMEOUT = "QUEUE_GAP_TIMEOUT"
+	CONFIG_DEDUP_WINDOW      = "DEDUP_WINDOW"
+	CONFIG_DEDUP_BY_SOURCE   = "DEDUP_BY_SOURCE"
+	CONFIG_ADMIN             = "ADMIN"
+	CONFIG_SHUTDOWN_TIMEOUT  = "SHUTDOWN_TIMEOUT"
+	CONFIG_LOG_FORMAT        = "LOG_FORMAT"
+	CONFIG_LOG_COMPONENTS    = "LOG_COMPONENTS"
+	CONFIG_LOG_USERS         = "LOG_USERS"
+	CONFIG_LOG_SAMPLE_FIRST  = "LOG_SAMPLE_FIRST"
+	CONFIG_LOG_SAMPLE_EVERY  = "LOG_SAMPLE_EVERY"
+	CONFIG_EVENT_SOURCE_JSON   = "EVENT_SOURCE_JSON"
+	CONFIG_EVENT_SOURCE_BINARY = "EVENT_SOURCE_BINARY"
+	CONFIG_ROUTER_SHARDS       = "ROUTER_SHARDS"
+	CONFIG_ROUTER_FANOUT_WORKERS   = "ROUTER_FANOUT_WORKERS"
+	CONFIG_ROUTER_FANOUT_THRESHOLD = "ROUTER_FANOUT_THRESHOLD"
+
+	// a path to a config file, it is also set by the flag -config
+	CONFIG_FILE       = "CONFIG_FILE"
+	CONFIG_FLAG_FILE  = "config"
+	CONFIG_FLAG_PRINT = "print-config"
+)
+
+var (
+	// parameters applied by reloading a config, others require a restart
+	CONFIG_RELOADABLE = map[string]bool{
+		CONFIG_LOG_LEVEL:         true,
+		CONFIG_LOG_FORMAT:        true,
+		CONFIG_LOG_COMPONENTS:    true,
+		CONFIG_LOG_USERS:         true,
+		CONFIG_LOG_SAMPLE_FIRST:  true,
+		CONFIG_LOG_SAMPLE_EVERY:  true,
+		CONFIG_QUEUE_LIMIT:       true,
+		CONFIG_QUEUE_TTL:         true,
+		CONFIG_QUEUE_GAP_TIMEOUT: true,
+		CONFIG_MAILBOX_SIZE:      true,
+		CONFIG_MAILBOX_TTL:       true,
+		CONFIG_HISTORY_SIZE:      true,
+		CONFIG_HISTORY_TTL:       true,
+		CONFIG_CLIENT_BUFFER:     true,
+		CONFIG_CLIENT_OVERFLOW:   true,
+		CONFIG_CLIENT_UNACKED:    true,
+		CONFIG_SHUTDOWN_TIMEOUT:  true,
+	}
 )
 
 type Config struct {
@@ -27,6 +118,52 @@ type Config struct {
 	queueLimit    int64
 	queueTTL      int64
 	logLevel      int
+
+	walDir          string
+	walSegmentSize  int64
+	walSync         WalSyncPolicy
+	walSyncInterval int64
+
+	snapshotPath     string
+	snapshotInterval int64
+
+	mailboxSize int64
+	mailboxTTL  int64
+	historySize int64
+	historyTTL  int64
+
+	clientBuffer   int64
+	clientOverflow OverflowPolicy
+	clientUnacked  int64
+
+	queueMode       QueueMode
+	queueGapTimeout int64
+
+	dedupWindow   int64
+	dedupBySource bool
+
+	admin string
+
+	shutdownTimeout int64
+
+	logFormat int
+	// overrides of a log level by components and by user ids
+	logComponents map[string]int
+	logUsers      map[int64]int
+	// sampling of lines of each call site
+	logSampleFirst int64
+	logSampleEvery int64
+
+	// listening addresses of event sources in other wire formats
+	eventSourceJson   string
+	eventSourceBinary string
+
+	routerShards          int64
+	routerFanoutWorkers   int64
+	routerFanoutThreshold int64
+
+	// not a parameter, the server prints the config and exits
+	printConfig bool
 }
 
 func (o *Config) EventSource() string {
@@ -49,26 +186,283 @@ func (o *Config) LogLevel() int {
 	return o.logLevel
 }
 
-func ParseConfig() (*Config, error) {
-	eventSource, err := ParseAddress(os.Getenv(CONFIG_EVENT_SOURCE), DEFAULT_EVENT_SOURCE)
-	if err != nil {
-		return nil, errors.New("failed to parse an event source config parameter: " + err.Error())
+func (o *Config) WalDir() string {
+	return o.walDir
+}
+
+func (o *Config) WalSegmentSize() int64 {
+	return o.walSegmentSize
+}
+
+func (o *Config) WalSync() WalSyncPolicy {
+	return o.walSync
+}
+
+func (o *Config) WalSyncInterval() time.Duration {
+	return time.Duration(o.walSyncInterval) * time.Millisecond
+}
+
+func (o *Config) SnapshotPath() string {
+	return o.snapshotPath
+}
+
+func (o *Config) SnapshotInterval() time.Duration {
+	return time.Duration(o.snapshotInterval) * time.Millisecond
+}
+
+func (o *Config) MailboxSize() int {
+	return int(o.mailboxSize)
+}
+
+func (o *Config) MailboxTTL() time.Duration {
+	return time.Duration(o.mailboxTTL) * time.Millisecond
+}
+
+func (o *Config) HistorySize() int {
+	return int(o.historySize)
+}
+
+func (o *Config) HistoryTTL() time.Duration {
+	return time.Duration(o.historyTTL) * time.Millisecond
+}
+
+func (o *Config) ClientBuffer() int {
+	return int(o.clientBuffer)
+}
+
+func (o *Config) ClientOverflow() OverflowPolicy {
+	return o.clientOverflow
+}
+
+func (o *Config) ClientUnackedLimit() int {
+	return int(o.clientUnacked)
+}
+
+func (o *Config) QueueMode() QueueMode {
+	return o.queueMode
+}
+
+func (o *Config) QueueGapTimeout() time.Duration {
+	return time.Duration(o.queueGapTimeout) * time.Millisecond
+}
+
+func (o *Config) DedupWindow() int {
+	return int(o.dedupWindow)
+}
+
+func (o *Config) DedupBySource() bool {
+	return o.dedupBySource
+}
+
+func (o *Config) Admin() string {
+	return o.admin
+}
+
+func (o *Config) ShutdownTimeout() time.Duration {
+	return time.Duration(o.shutdownTimeout) * time.Millisecond
+}
+
+func (o *Config) LogFormat() int {
+	return o.logFormat
+}
+
+func (o *Config) LogComponents() map[string]int {
+	return o.logComponents
+}
+
+func (o *Config) LogUsers() map[int64]int {
+	return o.logUsers
+}
+
+func (o *Config) LogSampleFirst() int {
+	return int(o.logSampleFirst)
+}
+
+func (o *Config) LogSampleEvery() int {
+	return int(o.logSampleEvery)
+}
+
+func (o *Config) EventSourceJson() string {
+	return o.eventSourceJson
+}
+
+func (o *Config) EventSourceBinary() string {
+	return o.eventSourceBinary
+}
+
+func (o *Config) RouterShards() int {
+	return int(o.routerShards)
+}
+
+func (o *Config) RouterFanoutWorkers() int {
+	return int(o.routerFanoutWorkers)
+}
+
+func (o *Config) RouterFanoutThreshold() int {
+	return int(o.routerFanoutThreshold)
+}
+
+func (o *Config) PrintConfig() bool {
+	return o.printConfig
+}
+
+// String lists parameters as "NAME=value" separated by "; "
+func (o *Config) String() string {
+	dump := o.Dump()
+
+	result := make([]string, 0, len(configParams))
+	for _, param := range configParams {
+		result = append(result, fmt.Sprint(param.name, "=", dump[param.name]))
+	}
+
+	return strings.Join(result, "; ")
+}
+
+// Print writes parameters as lines "NAME = value" in a format of a config file
+func (o *Config) Print(w io.Writer) {
+	dump := o.Dump()
+
+	for _, param := range configParams {
+		value := fmt.Sprint(dump[param.name])
+		if _, ok := dump[param.name].(string); ok {
+			value = strconv.Quote(value)
+		}
+
+		fmt.Fprintln(w, param.name, "=", value)
+	}
+}
+
+// Dump returns parameters by names of environment variables
+func (o *Config) Dump() map[string]interface{} {
+	return map[string]interface{}{
+		CONFIG_EVENT_SOURCE:      o.EventSource(),
+		CONFIG_CLIENT:            o.Client(),
+		CONFIG_QUEUE_LIMIT:       o.QueueLimit(),
+		CONFIG_QUEUE_TTL:         o.queueTTL,
+		CONFIG_LOG_LEVEL:         logger.LevelToString(o.LogLevel()),
+		CONFIG_WAL_DIR:           o.WalDir(),
+		CONFIG_WAL_SEGMENT_SIZE:  o.WalSegmentSize(),
+		CONFIG_WAL_SYNC:          o.WalSync().String(),
+		CONFIG_WAL_SYNC_INTERVAL: o.walSyncInterval,
+		CONFIG_SNAPSHOT_PATH:     o.SnapshotPath(),
+		CONFIG_SNAPSHOT_INTERVAL: o.snapshotInterval,
+		CONFIG_MAILBOX_SIZE:      o.MailboxSize(),
+		CONFIG_MAILBOX_TTL:       o.mailboxTTL,
+		CONFIG_HISTORY_SIZE:      o.HistorySize(),
+		CONFIG_HISTORY_TTL:       o.historyTTL,
+		CONFIG_CLIENT_BUFFER:     o.ClientBuffer(),
+		CONFIG_CLIENT_OVERFLOW:   o.ClientOverflow().String(),
+		CONFIG_CLIENT_UNACKED:    o.ClientUnackedLimit(),
+		CONFIG_QUEUE_MODE:        o.QueueMode().String(),
+		CONFIG_QUEUE_GAP_TIMEOUT: o.queueGapTimeout,
+		CONFIG_DEDUP_WINDOW:      o.DedupWindow(),
+		CONFIG_DEDUP_BY_SOURCE:   o.DedupBySource(),
+		CONFIG_ADMIN:             o.Admin(),
+		CONFIG_SHUTDOWN_TIMEOUT:  o.shutdownTimeout,
+		CONFIG_LOG_FORMAT:        logger.FormatToString(o.LogFormat()),
+		CONFIG_LOG_COMPONENTS:    FormatLevelOverrides(o.LogComponents()),
+		CONFIG_LOG_USERS:         FormatLevelOverrides(o.LogUsers()),
+		CONFIG_LOG_SAMPLE_FIRST:  o.LogSampleFirst(),
+		CONFIG_LOG_SAMPLE_EVERY:  o.LogSampleEvery(),
+		CONFIG_EVENT_SOURCE_JSON:   o.EventSourceJson(),
+		CONFIG_EVENT_SOURCE_BINARY: o.EventSourceBinary(),
+		CONFIG_ROUTER_SHARDS:       o.RouterShards(),
+		CONFIG_ROUTER_FANOUT_WORKERS:   o.RouterFanoutWorkers(),
+		CONFIG_ROUTER_FANOUT_THRESHOLD: o.RouterFanoutThreshold(),
+	}
+}
+
+// Reload returns a copy of the config with reloadable parameters of a new config
+func (o *Config) Reload(config *Config) *Config {
+	result := *o
+
+	result.logLevel = config.logLevel
+	result.logFormat = config.logFormat
+	result.logComponents = config.logComponents
+	result.logUsers = config.logUsers
+	result.logSampleFirst = config.logSampleFirst
+	result.logSampleEvery = config.logSampleEvery
+	result.queueLimit = config.queueLimit
+	result.queueTTL = config.queueTTL
+	result.queueGapTimeout = config.queueGapTimeout
+	result.mailboxSize = config.mailboxSize
+	result.mailboxTTL = config.mailboxTTL
+	result.historySize = config.historySize
+	result.historyTTL = config.historyTTL
+	result.clientBuffer = config.clientBuffer
+	result.clientOverflow = config.clientOverflow
+	result.clientUnacked = config.clientUnacked
+	result.shutdownTimeout = config.shutdownTimeout
+
+	return &result
+}
+
+// ParseConfig reads parameters with precedence: flags > environment variables > a config file > defaults.
+// Invalid values of all parameters are reported together.
+func ParseConfig(args []string) (*Config, error) {
+	flags := flag.NewFlagSet("queserver", flag.ContinueOnError)
+
+	configFile := flags.String(CONFIG_FLAG_FILE, "", "path to a config file, .json or lines NAME = value")
+	printConfig := flags.Bool(CONFIG_FLAG_PRINT, false, "print the config and exit")
+
+	flagValues := make(map[string]*string)
+	for _, param := range configParams {
+		flagValues[param.name] = flags.String(param.Flag(), "", param.usage)
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return nil, errors.New("failed to parse flags: " + err.Error())
+	}
+
+	if flags.NArg() > 0 {
+		return nil, errors.New("unexpected arguments: " + strings.Join(flags.Args(), " "))
+	}
+
+	setFlags := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) {
+		setFlags[f.Name] = true
+	})
+
+	if !setFlags[CONFIG_FLAG_FILE] {
+		*configFile = os.Getenv(CONFIG_FILE)
+	}
+
+	fileValues := map[string]string{}
+	if *configFile != "" {
+		var err error
+
+		fileValues, err = ReadConfigFile(*configFile)
+		if err != nil {
+			return nil, errors.New("failed to read a config file: " + err.Error())
+		}
+	}
+
+	config := &Config{
+		printConfig: *printConfig,
+	}
+	failed := []string{}
+
+	for _, param := range configParams {
+		value, ok := *flagValues[param.name], setFlags[param.Flag()]
+		if !ok {
+			value = os.Getenv(param.name)
+			ok = value != ""
+		}
+		if !ok {
+			value, ok = fileValues[param.name]
+		}
+		if !ok {
+			value = param.defaultValue
+		}
+
+		if err := param.parse(config, strings.TrimSpace(value)); err != nil {
+			failed = append(failed, param.name+"='"+value+"': "+err.Error())
+		}
 	}
 
-	port, err := ParseAddress(os.Getenv(CONFIG_CLIENT), DEFAULT_CLIENT)
-	if err != nil {
-		return nil, errors.New("failed to parse a client config parameter: " + err.Error())
+	if len(failed) > 0 {
+		return nil, errors.New("invalid config parameters: " + strings.Join(failed, "; "))
 	}
 
-	queueLimit := ParseInt64(os.Getenv(CONFIG_QUEUE_LIMIT), DEFAULT_QUEUE_LIMIT)
-	queueTTL := ParseInt64(os.Getenv(CONFIG_QUEUE_TTL), DEFAULT_QUEUE_TTL)
-	logLevel := logger.ParseLevel(os.Getenv(CONFIG_LOG_LEVEL), DEFAULT_LOG_LEVEL)
-
-	return &Config{
-		eventSource:   eventSource,
-		client:        port,
-		queueLimit:    queueLimit,
-		queueTTL:      queueTTL,
-		logLevel:      logLevel,
-	}, nil
+	return config, nil
 }
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"bufio"
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+	"io/ioutil"
+	"encoding/json"
+	"path/filepath"
+)
+
+// ReadConfigFile reads values of parameters by names of environment variables.
+// A file with the extension .json is a JSON object, other files are lines "NAME = value"
+// with optional quotes of values and comments started by #.
+func ReadConfigFile(path string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var values map[string]string
+
+	if strings.ToLower(filepath.Ext(path)) == ".json" {
+		values, err = parseConfigJson(data)
+	} else {
+		values, err = parseConfigLines(data)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	known := make(map[string]bool)
+	for _, param := range configParams {
+		known[param.name] = true
+	}
+
+	for name := range values {
+		if !known[name] {
+			return nil, errors.New("unknown parameter " + name)
+		}
+	}
+
+	return values, nil
+}
+
+func parseConfigJson(data []byte) (map[string]string, error) {
+	raw := map[string]interface{}{}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string)
+
+	for name, value := range raw {
+		switch v := value.(type) {
+		case string:
+			values[strings.ToUpper(name)] = v
+		case json.Number, bool:
+			values[strings.ToUpper(name)] = fmt.Sprint(v)
+		default:
+			return nil, errors.New("unsupported value of " + name)
+		}
+	}
+
+	return values, nil
+}
+
+func parseConfigLines(data []byte) (map[string]string, error) {
+	values := make(map[string]string)
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("line %d: expected NAME = value", lineNo)
+		}
+
+		name := strings.ToUpper(strings.TrimSpace(parts[0]))
+		value := strings.TrimSpace(parts[1])
+
+		if strings.HasPrefix(value, "\"") {
+			unquoted, err := strconv.Unquote(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid quoted value", lineNo)
+			}
+
+			value = unquoted
+		} else if i := strings.Index(value, "#"); i >= 0 {
+			value = strings.TrimSpace(value[:i])
+		}
+
+		if _, ok := values[name]; ok {
+			return nil, fmt.Errorf("line %d: duplicate parameter %s", lineNo, name)
+		}
+
+		values[name] = value
+	}
+
+	return values, scanner.Err()
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"errors"
+	"strconv"
+	"strings"
+	"github.com/7phs/coding-challenge-queserver/logger"
+)
+
+// configParam describes a parameter by a name of an environment variable, the same name is used in a config file
+// and in lower case with dashes as a flag
+type configParam struct {
+	name         string
+	usage        string
+	defaultValue string
+	parse        func(*Config, string) error
+}
+
+func (o *configParam) Flag() string {
+	return strings.Replace(strings.ToLower(o.name), "_", "-", -1)
+}
+
+var (
+	configParams = []*configParam{
+		{
+			name: CONFIG_EVENT_SOURCE, usage: "listening address of event sources", defaultValue: DEFAULT_EVENT_SOURCE,
+			parse: func(o *Config, v string) error { return parseConfigAddress(v, false, &o.eventSource) },
+		},
+		{
+			name: CONFIG_EVENT_SOURCE_JSON, usage: "listening address of event sources sending JSON lines, empty to disable", defaultValue: DEFAULT_EVENT_SOURCE_JSON,
+			parse: func(o *Config, v string) error { return parseConfigAddress(v, true, &o.eventSourceJson) },
+		},
+		{
+			name: CONFIG_EVENT_SOURCE_BINARY, usage: "listening address of event sources sending length-prefixed binary frames, empty to disable", defaultValue: DEFAULT_EVENT_SOURCE_BINARY,
+			parse: func(o *Config, v string) error { return parseConfigAddress(v, true, &o.eventSourceBinary) },
+		},
+		{
+			name: CONFIG_CLIENT, usage: "listening address of clients", defaultValue: DEFAULT_CLIENT,
+			parse: func(o *Config, v string) error { return parseConfigAddress(v, false, &o.client) },
+		},
+		{
+			name: CONFIG_QUEUE_LIMIT, usage: "sequenceId threshold to release messages", defaultValue: fmt.Sprint(DEFAULT_QUEUE_LIMIT),
+			parse: func(o *Config, v string) error { return parseConfigInt64(v, 0, &o.queueLimit) },
+		},
+		{
+			name: CONFIG_QUEUE_TTL, usage: "time to wait for messages in the queue, milliseconds", defaultValue: fmt.Sprint(DEFAULT_QUEUE_TTL),
+			// the queue pulls messages each TTL, zero spins it
+			parse: func(o *Config, v string) error { return parseConfigInt64(v, 1, &o.queueTTL) },
+		},
+		{
+			name: CONFIG_LOG_LEVEL, usage: "Debug, Info, Warning or Error", defaultValue: logger.LevelToString(DEFAULT_LOG_LEVEL),
+			parse: func(o *Config, v string) error {
+				if o.logLevel = logger.ParseLevel(v, 0); o.logLevel == 0 {
+					return errors.New("unknown log level")
+				}
+
+				return nil
+			},
+		},
+		{
+			name: CONFIG_LOG_FORMAT, usage: "Text or Json", defaultValue: logger.FormatToString(DEFAULT_LOG_FORMAT),
+			parse: func(o *Config, v string) error {
+				if o.logFormat = logger.ParseFormat(v, 0); o.logFormat == 0 {
+					return errors.New("unknown log format")
+				}
+
+				return nil
+			},
+		},
+		{
+			name: CONFIG_LOG_COMPONENTS, usage: "log levels of components, e.g. CLIENT=debug,ROUTER=warning", defaultValue: DEFAULT_LOG_COMPONENTS,
+			parse: func(o *Config, v string) error {
+				o.logComponents = make(map[string]int)
+
+				return parseLevelOverrides(v, func(key string, level int) error {
+					o.logComponents[strings.ToUpper(key)] = level
+					return nil
+				})
+			},
+		},
+		{
+			name: CONFIG_LOG_USERS, usage: "log levels of lines of users, e.g. 1001=debug", defaultValue: DEFAULT_LOG_USERS,
+			parse: func(o *Config, v string) error {
+				o.logUsers = make(map[int64]int)
+
+				return parseLevelOverrides(v, func(key string, level int) error {
+					userId, err := strconv.ParseInt(key, 10, 64)
+					if err != nil {
+						return errors.New("invalid user id " + key)
+					}
+
+					o.logUsers[userId] = level
+					return nil
+				})
+			},
+		},
+		{
+			name: CONFIG_LOG_SAMPLE_FIRST, usage: "lines of a call site logged in a second before sampling, 0 to disable", defaultValue: fmt.Sprint(DEFAULT_LOG_SAMPLE_FIRST),
+			parse: func(o *Config, v string) error { return parseConfigInt64(v, 0, &o.logSampleFirst) },
+		},
+		{
+			name: CONFIG_LOG_SAMPLE_EVERY, usage: "log every n-th line of a call site after the first ones, 0 to drop them", defaultValue: fmt.Sprint(DEFAULT_LOG_SAMPLE_EVERY),
+			parse: func(o *Config, v string) error { return parseConfigInt64(v, 0, &o.logSampleEvery) },
+		},
+		{
+			name: CONFIG_WAL_DIR, usage: "directory of the write-ahead log, empty to disable", defaultValue: DEFAULT_WAL_DIR,
+			parse: func(o *Config, v string) error { o.walDir = v; return nil },
+		},
+		{
+			name: CONFIG_WAL_SEGMENT_SIZE, usage: "size of a segment of the write-ahead log, bytes", defaultValue: fmt.Sprint(DEFAULT_WAL_SEGMENT_SIZE),
+			parse: func(o *Config, v string) error { return parseConfigInt64(v, 1, &o.walSegmentSize) },
+		},
+		{
+			name: CONFIG_WAL_SYNC, usage: "Always, Interval or Never", defaultValue: DEFAULT_WAL_SYNC.String(),
+			parse: func(o *Config, v string) error {
+				if o.walSync = ParseWalSyncPolicy(v, 0); o.walSync == 0 {
+					return errors.New("unknown sync policy")
+				}
+
+				return nil
+			},
+		},
+		{
+			name: CONFIG_WAL_SYNC_INTERVAL, usage: "interval of syncing the write-ahead log, milliseconds", defaultValue: fmt.Sprint(DEFAULT_WAL_SYNC_INTERVAL),
+			parse: func(o *Config, v string) error { return parseConfigInt64(v, 1, &o.walSyncInterval) },
+		},
+		{
+			name: CONFIG_SNAPSHOT_PATH, usage: "path of a snapshot of the follower graph, empty to disable", defaultValue: DEFAULT_SNAPSHOT_PATH,
+			parse: func(o *Config, v string) error { o.snapshotPath = v; return nil },
+		},
+		{
+			name: CONFIG_SNAPSHOT_INTERVAL, usage: "interval of taking snapshots, milliseconds", defaultValue: fmt.Sprint(DEFAULT_SNAPSHOT_INTERVAL),
+			parse: func(o *Config, v string) error { return parseConfigInt64(v, 1, &o.snapshotInterval) },
+		},
+		{
+			name: CONFIG_MAILBOX_SIZE, usage: "messages stored for an offline user", defaultValue: fmt.Sprint(DEFAULT_MAILBOX_SIZE),
+			parse: func(o *Config, v string) error { return parseConfigInt64(v, 0, &o.mailboxSize) },
+		},
+		{
+			name: CONFIG_MAILBOX_TTL, usage: "time to live of stored messages, milliseconds", defaultValue: fmt.Sprint(DEFAULT_MAILBOX_TTL),
+			parse: func(o *Config, v string) error { return parseConfigInt64(v, 0, &o.mailboxTTL) },
+		},
+		{
+			name: CONFIG_CLIENT_BUFFER, usage: "outbound buffer of a client, messages", defaultValue: fmt.Sprint(DEFAULT_CLIENT_BUFFER),
+			parse: func(o *Config, v string) error { return parseConfigInt64(v, 1, &o.clientBuffer) },
+		},
+		{
+			name: CONFIG_CLIENT_OVERFLOW, usage: "DropOldest, DropNewest or Disconnect", defaultValue: DEFAULT_CLIENT_OVERFLOW.String(),
+			parse: func(o *Config, v string) error {
+				if o.clientOverflow = ParseOverflowPolicy(v, 0); o.clientOverflow == 0 {
+					return errors.New("unknown overflow policy")
+				}
+
+				return nil
+			},
+		},
+		{
+			name: CONFIG_QUEUE_MODE, usage: "Limit or Strict", defaultValue: DEFAULT_QUEUE_MODE.String(),
+			parse: func(o *Config, v string) error {
+				if o.queueMode = ParseQueueMode(v, 0); o.queueMode == 0 {
+					return errors.New("unknown queue mode")
+				}
+
+				return nil
+			},
+		},
+		{
+			name: CONFIG_QUEUE_GAP_TIMEOUT, usage: "time to wait for a gap in the strict mode, milliseconds", defaultValue: fmt.Sprint(DEFAULT_QUEUE_GAP_TIMEOUT),
+			parse: func(o *Config, v string) error { return parseConfigInt64(v, 0, &o.queueGapTimeout) },
+		},
+		{
+			name: CONFIG_DEDUP_WINDOW, usage: "recent sequenceId checked for duplicates", defaultValue: fmt.Sprint(DEFAULT_DEDUP_WINDOW),
+			parse: func(o *Config, v string) error { return parseConfigInt64(v, 0, &o.dedupWindow) },
+		},
+		{
+			name: CONFIG_DEDUP_BY_SOURCE, usage: "track sequenceId per event source", defaultValue: fmt.Sprint(DEFAULT_DEDUP_BY_SOURCE),
+			parse: func(o *Config, v string) (err error) {
+				if o.dedupBySource, err = strconv.ParseBool(v); err != nil {
+					return errors.New("not a boolean")
+				}
+
+				return nil
+			},
+		},
+		{
+			name: CONFIG_CLIENT_UNACKED, usage: "unacknowledged messages kept for a client", defaultValue: fmt.Sprint(DEFAULT_CLIENT_UNACKED),
+			parse: func(o *Config, v string) error { return parseConfigInt64(v, 0, &o.clientUnacked) },
+		},
+		{
+			name: CONFIG_HISTORY_SIZE, usage: "messages retained for resuming a client", defaultValue: fmt.Sprint(DEFAULT_HISTORY_SIZE),
+			parse: func(o *Config, v string) error { return parseConfigInt64(v, 0, &o.historySize) },
+		},
+		{
+			name: CONFIG_HISTORY_TTL, usage: "time to live of retained messages, milliseconds", defaultValue: fmt.Sprint(DEFAULT_HISTORY_TTL),
+			parse: func(o *Config, v string) error { return parseConfigInt64(v, 0, &o.historyTTL) },
+		},
+		{
+			name: CONFIG_ADMIN, usage: "listening address of the admin API, empty to disable", defaultValue: DEFAULT_ADMIN,
+			parse: func(o *Config, v string) error { return parseConfigAddress(v, true, &o.admin) },
+		},
+		{
+			name: CONFIG_ROUTER_SHARDS, usage: "shards of the router sending messages in own goroutines, 0 sends on the goroutine of the queue", defaultValue: fmt.Sprint(DEFAULT_ROUTER_SHARDS),
+			parse: func(o *Config, v string) error { return parseConfigInt64(v, 0, &o.routerShards) },
+		},
+		{
+			name: CONFIG_ROUTER_FANOUT_WORKERS, usage: "workers sending a message to parts of a large list of recipients, 0 to disable", defaultValue: fmt.Sprint(DEFAULT_ROUTER_FANOUT_WORKERS),
+			parse: func(o *Config, v string) error { return parseConfigInt64(v, 0, &o.routerFanoutWorkers) },
+		},
+		{
+			name: CONFIG_ROUTER_FANOUT_THRESHOLD, usage: "recipients of a part of a fan-out sent by a worker", defaultValue: fmt.Sprint(DEFAULT_ROUTER_FANOUT_THRESHOLD),
+			parse: func(o *Config, v string) error { return parseConfigInt64(v, 1, &o.routerFanoutThreshold) },
+		},
+		{
+			name: CONFIG_SHUTDOWN_TIMEOUT, usage: "time to drain buffers of clients on shutdown, milliseconds", defaultValue: fmt.Sprint(DEFAULT_SHUTDOWN_TIMEOUT),
+			parse: func(o *Config, v string) error { return parseConfigInt64(v, 0, &o.shutdownTimeout) },
+		},
+	}
+)
+
+// parseLevelOverrides parses a list "key=level,key=level"
+func parseLevelOverrides(v string, add func(key string, level int) error) error {
+	if v == "" {
+		return nil
+	}
+
+	for _, item := range strings.Split(v, ",") {
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return errors.New("expected key=level in '" + item + "'")
+		}
+
+		level := logger.ParseLevel(strings.TrimSpace(parts[1]), 0)
+		if level == 0 {
+			return errors.New("unknown log level in '" + item + "'")
+		}
+
+		if err := add(strings.TrimSpace(parts[0]), level); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// FormatLevelOverrides writes overrides of a log level as a sorted list "key=level,key=level"
+func FormatLevelOverrides(overrides interface{}) string {
+	result := []string{}
+
+	switch v := overrides.(type) {
+	case map[string]int:
+		for key, level := range v {
+			result = append(result, key+"="+logger.LevelToString(level))
+		}
+	case map[int64]int:
+		for key, level := range v {
+			result = append(result, strconv.FormatInt(key, 10)+"="+logger.LevelToString(level))
+		}
+	}
+
+	sort.Strings(result)
+
+	return strings.Join(result, ",")
+}
+
+func parseConfigInt64(v string, min int64, target *int64) error {
+	result, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return errors.New("not an integer")
+	}
+
+	if result < min {
+		return fmt.Errorf("less than %d", min)
+	}
+
+	*target = result
+
+	return nil
+}
+
+func parseConfigAddress(v string, optional bool, target *string) error {
+	if v == "" && optional {
+		*target = ""
+		return nil
+	}
+
+	result, err := ParseAddress(v, "")
+	if err != nil {
+		return err
+	}
+
+	*target = result
+
+	return nil
+}
@@ -2,6 +2,9 @@ package main
 
 import (
 	"os"
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
 	"testing"
 	"time"
 	"github.com/7phs/coding-challenge-queserver/logger"
@@ -10,12 +13,14 @@ import (
 func SetUpParseCofigParameter() func() {
 	prev := map[string]string{}
 
-	for _, name := range []string{
-		CONFIG_CLIENT, CONFIG_EVENT_SOURCE,
-		CONFIG_QUEUE_LIMIT, CONFIG_QUEUE_TTL, CONFIG_LOG_LEVEL} {
-		prev[name] = os.Getenv(name)
+	for _, param := range configParams {
+		prev[param.name] = os.Getenv(param.name)
+		os.Setenv(param.name, "")
 	}
 
+	prev[CONFIG_FILE] = os.Getenv(CONFIG_FILE)
+	os.Setenv(CONFIG_FILE, "")
+
 	return func() {
 		for name, value := range prev {
 			os.Setenv(name, value)
@@ -37,17 +42,19 @@ func TestParseConfig(t *testing.T) {
 		expectedQueueTTL   time.Duration
 		logLevel           string
 		expectedLogLevel   int
+		expectedErr        bool
 	}{
 		{
 			expectedClient:     DEFAULT_CLIENT, expectedEventSrc: DEFAULT_EVENT_SOURCE,
 			expectedQueueLimit: DEFAULT_QUEUE_LIMIT, expectedQueueTTL: DEFAULT_QUEUE_TTL * time.Millisecond, expectedLogLevel: logger.CalcLevel(DEFAULT_LOG_LEVEL),
 		},
 		{
-			client:      ":sdfsdf", expectedClient: DEFAULT_CLIENT,
-			eventSource: ":dasdas", expectedEventSrc: DEFAULT_EVENT_SOURCE,
-			queueLimit:  "dkjadslj", expectedQueueLimit: DEFAULT_QUEUE_LIMIT,
-			queueTTL:    "asdasd", expectedQueueTTL: DEFAULT_QUEUE_TTL * time.Millisecond,
-			logLevel:    "unksljkdf", expectedLogLevel: logger.CalcLevel(DEFAULT_LOG_LEVEL),
+			client:      ":sdfsdf",
+			eventSource: ":dasdas",
+			queueLimit:  "dkjadslj",
+			queueTTL:    "asdasd",
+			logLevel:    "unksljkdf",
+			expectedErr: true,
 		},
 		{
 			client:      ":9090", expectedClient: ":9090",
@@ -65,10 +72,18 @@ func TestParseConfig(t *testing.T) {
 		os.Setenv(CONFIG_QUEUE_TTL, test.queueTTL)
 		os.Setenv(CONFIG_LOG_LEVEL, test.logLevel)
 
-		params, err := ParseConfig()
+		params, err := ParseConfig(nil)
+
+		if test.expectedErr {
+			if err == nil {
+				t.Error(i, ": failed to catch invalid parameters")
+			}
+			continue
+		}
 
 		if err != nil {
-			t.Error("failed to parse config")
+			t.Error(i, ": failed to parse config with error: ", err)
+			continue
 		}
 
 		if exist := params.EventSource(); exist != test.expectedEventSrc {
@@ -92,3 +107,116 @@ func TestParseConfig(t *testing.T) {
 		}
 	}
 }
+
+func TestParseConfig_Precedence(t *testing.T) {
+	defer SetUpParseCofigParameter()()
+
+	dir := t.TempDir()
+
+	jsonFile := filepath.Join(dir, "config.json")
+	ioutil.WriteFile(jsonFile, []byte(`{"QUEUE_LIMIT": 10, "QUEUE_TTL": 20, "LOG_LEVEL": "debug", "DEDUP_BY_SOURCE": true}`), 0644)
+
+	linesFile := filepath.Join(dir, "config.conf")
+	ioutil.WriteFile(linesFile, []byte("# queue\nQUEUE_LIMIT = 10\nqueue_ttl=20 # ms\nLOG_LEVEL = \"debug\"\n\nDEDUP_BY_SOURCE = true\n"), 0644)
+
+	for _, file := range []string{jsonFile, linesFile} {
+		os.Setenv(CONFIG_FILE, "")
+		os.Setenv(CONFIG_QUEUE_TTL, "30")
+
+		config, err := ParseConfig([]string{"-config", file, "-queue-limit", "5"})
+		if err != nil {
+			t.Error(file, ": failed to parse config with error: ", err)
+			continue
+		}
+
+		// flag > env > file > default
+		if exist := config.QueueLimit(); exist != 5 {
+			t.Error(file, ": failed to prefer a flag. Got ", exist, ", but expected is ", 5)
+		}
+		if exist := config.QueueTTL(); exist != 30*time.Millisecond {
+			t.Error(file, ": failed to prefer an environment variable. Got ", exist, ", but expected is ", 30*time.Millisecond)
+		}
+		if exist := config.LogLevel(); exist != logger.CalcLevel(logger.DEBUG) || !config.DedupBySource() {
+			t.Error(file, ": failed to read a file. Got ", exist, " and ", config.DedupBySource())
+		}
+		if exist := config.Client(); exist != DEFAULT_CLIENT {
+			t.Error(file, ": failed to use a default. Got ", exist, ", but expected is ", DEFAULT_CLIENT)
+		}
+
+		// a path of the file by an environment variable
+		os.Setenv(CONFIG_FILE, file)
+		os.Setenv(CONFIG_QUEUE_TTL, "")
+
+		config, err = ParseConfig(nil)
+		if err != nil || config.QueueTTL() != 20*time.Millisecond {
+			t.Error(file, ": failed to read a file by ", CONFIG_FILE, ". Got ", config, " with error ", err)
+		}
+	}
+}
+
+func TestParseConfig_Errors(t *testing.T) {
+	defer SetUpParseCofigParameter()()
+
+	dir := t.TempDir()
+
+	unknownFile := filepath.Join(dir, "unknown.conf")
+	ioutil.WriteFile(unknownFile, []byte("QUEUE_LIMITS = 10\n"), 0644)
+
+	brokenFile := filepath.Join(dir, "broken.json")
+	ioutil.WriteFile(brokenFile, []byte(`{"QUEUE_LIMIT": `), 0644)
+
+	testSuites := [][]string{
+		{"-queue-limit", "-1"},
+		{"-queue-ttl", "0"},
+		{"-client-buffer", "0"},
+		{"-wal-sync", "sometimes"},
+		{"-dedup-by-source", "maybe"},
+		{"-log-components", "CLIENT"},
+		{"-log-users", "abc=debug"},
+		{"-log-users", "1001=verbose"},
+		{"-unknown-flag", "1"},
+		{"extra"},
+		{"-config", unknownFile},
+		{"-config", brokenFile},
+		{"-config", filepath.Join(dir, "missing.conf")},
+	}
+
+	for _, args := range testSuites {
+		if _, err := ParseConfig(args); err == nil {
+			t.Error("failed to catch an error of ", args)
+		}
+	}
+}
+
+func TestConfig_Print(t *testing.T) {
+	defer SetUpParseCofigParameter()()
+
+	config, err := ParseConfig([]string{"-print-config", "-admin", ":9095", "-log-components", "router=error,CLIENT=debug", "-log-users", "7=debug"})
+	if err != nil {
+		t.Fatal("failed to parse config with error: ", err)
+	}
+
+	if !config.PrintConfig() {
+		t.Error("failed to parse -print-config")
+	}
+
+	if exist, expected := config.Dump()[CONFIG_LOG_COMPONENTS], "CLIENT=Debug,ROUTER=Error"; exist != expected {
+		t.Error("failed to parse log levels of components. Got ", exist, ", but expected is ", expected)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	config.Print(buf)
+
+	// the printed config is a valid config file
+	file := filepath.Join(t.TempDir(), "printed.conf")
+	ioutil.WriteFile(file, buf.Bytes(), 0644)
+
+	printed, err := ParseConfig([]string{"-config", file})
+	if err != nil {
+		t.Fatal("failed to parse a printed config with error: ", err)
+	}
+
+	if exist, expected := printed.String(), config.String(); exist != expected {
+		t.Error("failed to read a printed config. Got '", exist, "', but expected is '", expected, "'")
+	}
+}
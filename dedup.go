@@ -0,0 +1,86 @@
+package main
+
+import (
+	"sync"
+)
+
+type dedupKey struct {
+	source     string
+	sequenceId int64
+}
+
+// Deduplicator remembers the last accepted sequenceId (optionally per event source)
+// in a sliding window to reject replays of event sources retrying after a dropped connection.
+type Deduplicator struct {
+	sync.Mutex
+
+	size     int
+	bySource bool
+
+	seen   map[dedupKey]struct{}
+	window []dedupKey
+	next   int
+}
+
+func NewDeduplicator(size int, bySource bool) *Deduplicator {
+	return &Deduplicator{
+		size:     size,
+		bySource: bySource,
+		seen:     make(map[dedupKey]struct{}, MaxInt64(0, int64(size))),
+	}
+}
+
+// Seen reports a duplicate or remembers a new sequenceId evicting the oldest one out of the window
+func (o *Deduplicator) Seen(source string, sequenceId int64) bool {
+	if o.size <= 0 {
+		return false
+	}
+
+	key := dedupKey{sequenceId: sequenceId}
+	if o.bySource {
+		key.source = source
+	}
+
+	o.Lock()
+	defer o.Unlock()
+
+	if _, ok := o.seen[key]; ok {
+		return true
+	}
+
+	if len(o.window) < o.size {
+		o.window = append(o.window, key)
+	} else {
+		delete(o.seen, o.window[o.next])
+		o.window[o.next] = key
+		o.next = (o.next + 1) % o.size
+	}
+
+	o.seen[key] = struct{}{}
+
+	return false
+}
+
+// Forget removes a sequenceId of a message which is not accepted, so a retry of the source is not a duplicate
+func (o *Deduplicator) Forget(source string, sequenceId int64) {
+	if o.size <= 0 {
+		return
+	}
+
+	key := dedupKey{sequenceId: sequenceId}
+	if o.bySource {
+		key.source = source
+	}
+
+	o.Lock()
+	defer o.Unlock()
+
+	delete(o.seen, key)
+
+	// a free slot keeps a zero sequenceId, it doesn't belong to any valid message
+	for i := range o.window {
+		if o.window[i] == key {
+			o.window[i] = dedupKey{}
+		}
+	}
+}
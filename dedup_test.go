@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestDeduplicator(t *testing.T) {
+	dedup := NewDeduplicator(3, false)
+
+	testSuites := []*struct {
+		source   string
+		id       int64
+		expected bool
+	}{
+		{source: "a", id: 1, expected: false},
+		{source: "a", id: 2, expected: false},
+		{source: "b", id: 1, expected: true},
+		{source: "a", id: 3, expected: false},
+		{source: "a", id: 4, expected: false},
+		// out of the window
+		{source: "a", id: 1, expected: false},
+		{source: "a", id: 4, expected: true},
+	}
+
+	for i, test := range testSuites {
+		if exist := dedup.Seen(test.source, test.id); exist != test.expected {
+			t.Error(i, ": failed to check a duplicate #", test.id, " from '", test.source, "'. Got ", exist, ", but expected is ", test.expected)
+		}
+	}
+}
+
+func TestDeduplicator_BySource(t *testing.T) {
+	dedup := NewDeduplicator(10, true)
+
+	dedup.Seen("a", 1)
+
+	if dedup.Seen("b", 1) {
+		t.Error("failed to separate sequenceId of sources")
+	}
+
+	if !dedup.Seen("a", 1) {
+		t.Error("failed to catch a duplicate of a source")
+	}
+}
+
+func TestDeduplicator_Forget(t *testing.T) {
+	dedup := NewDeduplicator(3, false)
+
+	dedup.Seen("a", 1)
+	dedup.Seen("a", 2)
+	dedup.Forget("a", 2)
+
+	if dedup.Seen("a", 2) {
+		t.Error("failed to forget a sequenceId")
+	}
+
+	if !dedup.Seen("a", 1) {
+		t.Error("failed to keep other sequenceId after forgetting")
+	}
+}
+
+func TestDeduplicator_Disabled(t *testing.T) {
+	dedup := NewDeduplicator(0, false)
+
+	dedup.Seen("a", 1)
+
+	if dedup.Seen("a", 1) {
+		t.Error("failed to skip checking by a disabled deduplicator")
+	}
+}
@@ -4,29 +4,94 @@ import (
 	"net"
 	"bufio"
 	"sync"
+	"time"
+	"strconv"
+	"strings"
 	"github.com/7phs/coding-challenge-queserver/logger"
 )
 
 const (
-	EVENT_LOG_INTERVAL = 100000
+	EVENT_LOG_INTERVAL  = 100000
+	EVENT_WRITE_TIMEOUT = time.Second
+
+	// an event source negotiates acknowledgements by the first line "ACK" or "ACK <batch size>",
+	// the binary codec expects it as a body of the first frame
+	EVENT_ACK_COMMAND = "ACK"
+	// a pending batch of acknowledgements is sent after the interval without new messages
+	EVENT_ACK_FLUSH = 100 * time.Millisecond
+
+	EVENT_REPLY_ACK       = "ACK"
+	EVENT_REPLY_ACCEPTED  = "OK"
+	EVENT_REPLY_DUPLICATE = "DUP"
+	EVENT_REPLY_ERROR     = "ERR"
+	EVENT_REPLY_SHUTDOWN  = "BYE"
+
+	EVENT_ERROR_INVALID = "INVALID"
+	EVENT_ERROR_LATE    = "LATE"
+	EVENT_ERROR_STORE   = "STORE"
 )
 
+// eventAck is a state of acknowledgements negotiated by an event source
+type eventAck struct {
+	enabled      bool
+	batch        int
+	pending      int
+	lastAccepted int64
+}
+
+// parseEventAck recognizes a negotiation line of acknowledgements
+func parseEventAck(line string) (*eventAck, bool) {
+	parts := strings.Fields(line)
+	if len(parts) == 0 || len(parts) > 2 || parts[0] != EVENT_ACK_COMMAND {
+		return nil, false
+	}
+
+	ack := &eventAck{
+		enabled: true,
+		batch:   1,
+	}
+
+	if len(parts) == 2 {
+		batch, err := strconv.Atoi(parts[1])
+		if err != nil || batch < 1 {
+			return nil, false
+		}
+
+		ack.batch = batch
+	}
+
+	return ack, true
+}
+
+type EventQueue interface {
+	Accept(*Message) error
+	// Sync persists accepted messages before they are confirmed
+	Sync() error
+}
+
 type EventSource struct {
-	addr string
+	addr  string
+	codec EventCodec
 
 	listener   net.Listener
-	queue      MessageQueue
+	queue      EventQueue
 	statistics *Statistics
 
 	shutdown chan struct{}
 	wait     sync.WaitGroup
 }
 
-func NewEventSource(config *Config, queue MessageQueue, statistics *Statistics) (*EventSource, error) {
+func NewEventSource(config *Config, queue EventQueue, statistics *Statistics) (*EventSource, error) {
+	return NewCodecEventSource(config.EventSource(), &PipeCodec{}, queue, statistics)
+}
+
+// NewCodecEventSource listens to event sources sending messages in a wire format of the codec
+func NewCodecEventSource(addr string, codec EventCodec, queue EventQueue, statistics *Statistics) (*EventSource, error) {
 	return (&EventSource{
 		queue:      queue,
 		statistics: statistics,
-		addr:       config.EventSource(),
+		addr:       addr,
+		codec:      codec,
 		shutdown:   make(chan struct{}),
 	}).Listen()
 }
@@ -34,7 +99,7 @@ func NewEventSource(config *Config, queue MessageQueue, statistics *Statistics)
 func (o *EventSource) Listen() (s *EventSource, err error) {
 	s = o
 
-	logger.Info("[EVENT_SOURCE]: listen ", o.addr)
+	logger.Info("[EVENT_SOURCE]: listen ", o.addr, " (", o.codec.Name(), ")")
 
 	o.listener, err = net.Listen("tcp", o.addr)
 
@@ -88,22 +153,45 @@ func (o *EventSource) handleConnection(conn net.Conn) {
 	go func() {
 		logger.Info("[EVENT_SOURCE]: start processing goroutin")
 
-		reader := bufio.NewReader(conn)
-		readCh := make(chan interface{})
+		source := conn.RemoteAddr().String()
+		if host, _, err := net.SplitHostPort(source); err == nil {
+			source = host
+		}
+
+		var (
+			reader  = bufio.NewReader(conn)
+			readCh  = make(chan interface{})
+			ack     = &eventAck{}
+			first   = true
+			reading bool
+			flushCh <-chan time.Time
+		)
+
 		for {
-			// read message
-			go func() {
-				line, _, err := reader.ReadLine()
-				if err != nil {
-					readCh <- err
-				} else {
-					readCh <- line
-				}
-			}()
+			// read message, unless the previous read is still waiting
+			if !reading {
+				reading = true
+
+				go func() {
+					frame, err := o.codec.ReadFrame(reader)
+					if err != nil {
+						readCh <- err
+					} else {
+						readCh <- frame
+					}
+				}()
+			}
+
+			flushCh = nil
+			if ack.pending > 0 {
+				flushCh = time.After(EVENT_ACK_FLUSH)
+			}
 
 			// check shutdown
 			select {
 			case v := <-readCh:
+				reading = false
+
 				switch i := v.(type) {
 				case error:
 					logger.Error("[EVENT_SOURCE]: error while receive messages: ", i)
@@ -112,15 +200,45 @@ func (o *EventSource) handleConnection(conn net.Conn) {
 					return
 
 				case []byte:
-					msg := NewMessage(string(i))
+					if first {
+						first = false
+
+						if negotiated, ok := parseEventAck(string(i)); ok {
+							logger.Debug("[EVENT_SOURCE]: acknowledge messages by ", negotiated.batch)
+
+							ack = negotiated
+							o.reply(conn, EVENT_REPLY_ACK, int64(ack.batch))
+							continue
+						}
+					}
+
+					msg, err := o.codec.Decode(i)
+					msg.source = source
+
+					o.statistics.Add(MESSAGE_RECIEVE, msg.typ)
+
+					if err != nil {
+						logger.Warning("[EVENT_SOURCE]: malformed message #", logger.SequenceId(msg.sequenceId), ": ", err)
+						o.acknowledge(conn, ack, msg, invalidMessageErr)
+						continue
+					}
+
 					// push message
 					logger.Debug("[EVENT_SOURCE]: receive a message: ", msg)
 
-					o.statistics.Add(MESSAGE_RECIEVE, msg.typ)
-					o.queue.PushMessage(msg)
+					o.acknowledge(conn, ack, msg, o.queue.Accept(msg))
 				}
 
+			case <-flushCh:
+				o.flushAck(conn, ack)
+
 			case <-o.shutdown:
+				if ack.enabled {
+					o.flushAck(conn, ack)
+					// everything after the last accepted message is not accepted
+					o.reply(conn, EVENT_REPLY_SHUTDOWN, ack.lastAccepted)
+				}
+
 				logger.Info("[EVENT_SOURCE]: shutdown processing goroutin")
 				o.wait.Done()
 				return
@@ -129,6 +247,65 @@ func (o *EventSource) handleConnection(conn net.Conn) {
 	}()
 }
 
+// acknowledge replies on a result of accepting a message in the negotiated mode, a source without
+// negotiated acknowledgements doesn't read replies
+func (o *EventSource) acknowledge(conn net.Conn, ack *eventAck, msg *Message, err error) {
+	switch {
+	case !ack.enabled:
+		// nothing to reply without negotiated acknowledgements
+
+	case err == duplicateMessageErr:
+		o.reply(conn, EVENT_REPLY_DUPLICATE, msg.sequenceId)
+
+	case err == lateMessageErr:
+		o.reply(conn, EVENT_REPLY_ERROR, msg.sequenceId, EVENT_ERROR_LATE)
+
+	case err == storeMessageErr:
+		o.reply(conn, EVENT_REPLY_ERROR, msg.sequenceId, EVENT_ERROR_STORE)
+
+	case err != nil:
+		o.reply(conn, EVENT_REPLY_ERROR, msg.sequenceId, EVENT_ERROR_INVALID)
+
+	default:
+		ack.lastAccepted = msg.sequenceId
+		ack.pending++
+
+		if ack.pending >= ack.batch {
+			o.flushAck(conn, ack)
+		}
+	}
+}
+
+func (o *EventSource) flushAck(conn net.Conn, ack *eventAck) {
+	if ack.pending == 0 {
+		return
+	}
+
+	ack.pending = 0
+
+	// accepted messages are on a disk before confirming them, whatever a sync policy of the write-ahead log is
+	if err := o.queue.Sync(); err != nil {
+		o.reply(conn, EVENT_REPLY_ERROR, ack.lastAccepted, EVENT_ERROR_STORE)
+		return
+	}
+
+	o.reply(conn, EVENT_REPLY_ACCEPTED, ack.lastAccepted)
+}
+
+// reply sends a line "<code> <sequenceId> [<error>]" back to an event source
+func (o *EventSource) reply(conn net.Conn, code string, sequenceId int64, details ...string) {
+	line := code + " " + strconv.FormatInt(sequenceId, 10)
+	for _, detail := range details {
+		line += " " + detail
+	}
+
+	conn.SetWriteDeadline(time.Now().Add(EVENT_WRITE_TIMEOUT))
+
+	if _, err := conn.Write([]byte(line + "\r\n")); err != nil {
+		logger.Warning("[EVENT_SOURCE]: failed to reply ", code, " for #", logger.SequenceId(sequenceId), ": ", err)
+	}
+}
+
 func (o *EventSource) Shutdown() {
 	logger.Info("[EVENT_SOURCE]: shutdown")
 
@@ -9,6 +9,8 @@ import (
 	"reflect"
 	"sort"
 	"bufio"
+	"sync/atomic"
+	"time"
 )
 
 func TestNewEventSource(t *testing.T) {
@@ -92,3 +94,219 @@ func TestNewEventSource(t *testing.T) {
 	}
 
 }
+
+func TestEventSource_Duplicate(t *testing.T) {
+	randPort := fmt.Sprintf(":%d", 16000+rand.Intn(60000-16000))
+	testQueue := TestQueue{}
+	statistics := NewStatistics()
+
+	queue := NewQueue(&Config{
+		queueLimit:  1000,
+		dedupWindow: 100,
+	}, &testQueue, statistics)
+
+	eventSource, err := NewEventSource(&Config{
+		eventSource: randPort,
+	}, queue, statistics)
+
+	if err != nil {
+		t.Error("failed to implement an event source with err: ", err)
+		return
+	}
+	defer eventSource.Shutdown()
+
+	go eventSource.Run()
+
+	connection, err := net.Dial("tcp", randPort)
+	if err != nil {
+		t.Error("failed to connect as a client to ", randPort, " with error: ", err)
+		return
+	}
+	defer connection.Close()
+
+	connection.Write([]byte("1|B\r\n2|B\r\n1|B\r\n"))
+
+	for i := 0; i < 100 && atomic.LoadUint64(&statistics.duplicates) == 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+
+	if exist := atomic.LoadUint64(&statistics.duplicates); exist != 1 {
+		t.Error("failed to count a duplicate. Got ", exist, ", but expected is ", 1)
+	}
+
+	// a source without negotiated acknowledgements doesn't read replies
+	connection.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+
+	if line, _, err := bufio.NewReader(connection).ReadLine(); err == nil {
+		t.Error("failed to skip replies without negotiation. Got '", string(line), "'")
+	}
+}
+
+func TestParseEventAck(t *testing.T) {
+	testSuites := []*struct {
+		in            string
+		expectedOk    bool
+		expectedBatch int
+	}{
+		{in: "ACK", expectedOk: true, expectedBatch: 1},
+		{in: "ACK 50", expectedOk: true, expectedBatch: 50},
+		{in: "ACK 0"},
+		{in: "ACK abc"},
+		{in: "ACK 1 2"},
+		{in: "1|B"},
+		{},
+	}
+
+	for _, test := range testSuites {
+		ack, ok := parseEventAck(test.in)
+		if ok != test.expectedOk {
+			t.Error("failed to parse a negotiation '", test.in, "'. Got ", ok, ", but expected is ", test.expectedOk)
+			continue
+		}
+
+		if ok && ack.batch != test.expectedBatch {
+			t.Error("failed to parse a batch size of '", test.in, "'. Got ", ack.batch, ", but expected is ", test.expectedBatch)
+		}
+	}
+}
+
+func TestEventSource_Ack(t *testing.T) {
+	testSuites := []*struct {
+		flow     string
+		expected []string
+	}{
+		{
+			flow:     "ACK\r\n1|B\r\n0|B\r\n1|B\r\n2|J\r\n",
+			expected: []string{"ACK 1", "OK 1", "ERR 0 INVALID", "DUP 1", "ERR 2 INVALID", "BYE 1"},
+		},
+		{
+			flow:     "ACK 2\r\n1|B\r\n2|B\r\n3|B\r\n",
+			expected: []string{"ACK 2", "OK 2", "OK 3", "BYE 3"},
+		},
+	}
+
+	for _, test := range testSuites {
+		randPort := fmt.Sprintf(":%d", 16000+rand.Intn(60000-16000))
+		statistics := NewStatistics()
+
+		queue := NewQueue(&Config{
+			queueLimit:  1000,
+			dedupWindow: 100,
+		}, &TestQueue{}, statistics)
+
+		eventSource, err := NewEventSource(&Config{
+			eventSource: randPort,
+		}, queue, statistics)
+
+		if err != nil {
+			t.Error("failed to implement an event source with err: ", err)
+			return
+		}
+
+		go eventSource.Run()
+
+		connection, err := net.Dial("tcp", randPort)
+		if err != nil {
+			t.Error("failed to connect as a client to ", randPort, " with error: ", err)
+			eventSource.Shutdown()
+			return
+		}
+
+		connection.Write([]byte(test.flow))
+
+		reader := bufio.NewReader(connection)
+		exist := []string{}
+
+		for len(exist) < len(test.expected) {
+			if len(exist) == len(test.expected)-1 {
+				eventSource.Shutdown()
+			}
+
+			line, _, err := reader.ReadLine()
+			if err != nil {
+				t.Error("failed to read a reply with error: ", err)
+				break
+			}
+
+			exist = append(exist, string(line))
+		}
+
+		if !reflect.DeepEqual(exist, test.expected) {
+			t.Error("failed to acknowledge messages. Got ", exist, ", but expected is ", test.expected)
+		}
+
+		connection.Close()
+	}
+}
+
+func TestEventSource_Codec(t *testing.T) {
+	binaryAck := []byte{0, 0, 0, 3, 'A', 'C', 'K'}
+	binaryTruncated := append([]byte{0, 0, 0, 16}, EncodeBinaryFrame(2, MESSAGE_BROADCAST, 0, 0)[4:20]...)
+
+	testSuites := []*struct {
+		codec    EventCodec
+		flow     []byte
+		expected []string
+	}{
+		{
+			codec:    &JsonCodec{},
+			flow:     []byte("ACK\r\n{\"sequenceId\":1,\"type\":\"B\"}\r\n{\"sequenceId\":2,\"type\":\"F\",\"from\":3}\r\n"),
+			expected: []string{"ACK 1", "OK 1", "ERR 2 INVALID", "BYE 1"},
+		},
+		{
+			codec:    &BinaryCodec{},
+			flow:     append(append(binaryAck, EncodeBinaryFrame(1, MESSAGE_BROADCAST, 0, 0)...), binaryTruncated...),
+			expected: []string{"ACK 1", "OK 1", "ERR 2 INVALID", "BYE 1"},
+		},
+	}
+
+	for _, test := range testSuites {
+		randPort := fmt.Sprintf(":%d", 16000+rand.Intn(60000-16000))
+		statistics := NewStatistics()
+
+		queue := NewQueue(&Config{
+			queueLimit:  1000,
+			dedupWindow: 100,
+		}, &TestQueue{}, statistics)
+
+		eventSource, err := NewCodecEventSource(randPort, test.codec, queue, statistics)
+		if err != nil {
+			t.Error("failed to implement an event source with err: ", err)
+			return
+		}
+
+		go eventSource.Run()
+
+		connection, err := net.Dial("tcp", randPort)
+		if err != nil {
+			t.Error("failed to connect as a client to ", randPort, " with error: ", err)
+			eventSource.Shutdown()
+			return
+		}
+
+		connection.Write(test.flow)
+
+		reader := bufio.NewReader(connection)
+		exist := []string{}
+
+		for len(exist) < len(test.expected) {
+			if len(exist) == len(test.expected)-1 {
+				eventSource.Shutdown()
+			}
+
+			line, _, err := reader.ReadLine()
+			if err != nil {
+				t.Error("failed to read a reply with error: ", err)
+				break
+			}
+
+			exist = append(exist, string(line))
+		}
+
+		if !reflect.DeepEqual(exist, test.expected) {
+			t.Error("failed to acknowledge messages of ", test.codec.Name(), ". Got ", exist, ", but expected is ", test.expected)
+		}
+
+		connection.Close()
+	}
+}
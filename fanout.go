@@ -0,0 +1,146 @@
+package main
+
+import (
+	"sync"
+	"time"
+	"github.com/7phs/coding-challenge-queserver/logger"
+)
+
+// FanoutPool sends a message to parts of a large list of recipients by a bounded number of workers.
+// A fan-out returns after all parts are sent, so recipients get the next message after this one.
+type FanoutPool struct {
+	// guards the state of workers, a fan-out holds a read lock until all parts are sent
+	sync.RWMutex
+
+	workers   int
+	threshold int
+	// a fan-out is sent by the caller until workers are started and after they are stopped
+	running bool
+	stopped bool
+
+	tasks chan func()
+	wait  sync.WaitGroup
+}
+
+func NewFanoutPool(workers int, threshold int) *FanoutPool {
+	return &FanoutPool{
+		workers:   workers,
+		threshold: int(MaxInt64(int64(threshold), 1)),
+		tasks:     make(chan func()),
+	}
+}
+
+func (o *FanoutPool) Run() {
+	o.Lock()
+	defer o.Unlock()
+
+	if o.running || o.stopped {
+		return
+	}
+
+	logger.Info("[ROUTER]: start ", o.workers, " fan-out workers")
+
+	o.running = true
+
+	for i := 0; i < o.workers; i++ {
+		o.wait.Add(1)
+
+		go func() {
+			for task := range o.tasks {
+				task()
+			}
+
+			o.wait.Done()
+		}()
+	}
+}
+
+// parts returns a count of parts of a fan-out, a fan-out under the threshold is sent by the caller.
+// A pool has to be locked.
+func (o *FanoutPool) parts(recipients int) int {
+	if !o.running || o.workers == 0 || recipients < 2*o.threshold {
+		return 1
+	}
+
+	return int(MinInt64(int64(o.workers), int64(recipients/o.threshold)))
+}
+
+// Send calls send for each user splitting users between workers and waits for all of them
+func (o *FanoutPool) Send(users []*UserInfo, send func(*UserInfo)) {
+	o.RLock()
+	defer o.RUnlock()
+
+	parts := o.parts(len(users))
+	if parts == 1 {
+		for _, userInfo := range users {
+			send(userInfo)
+		}
+
+		return
+	}
+
+	var wait sync.WaitGroup
+
+	size := (len(users) + parts - 1) / parts
+	for start := 0; start < len(users); start += size {
+		part := users[start:int(MinInt64(int64(start+size), int64(len(users))))]
+
+		wait.Add(1)
+		o.tasks <- func() {
+			defer wait.Done()
+
+			for _, userInfo := range part {
+				send(userInfo)
+			}
+		}
+	}
+
+	wait.Wait()
+}
+
+// Shutdown waits for running fan-outs and stops workers, later fan-outs are sent by the caller
+func (o *FanoutPool) Shutdown() {
+	o.Lock()
+	defer o.Unlock()
+
+	if o.stopped {
+		return
+	}
+
+	o.stopped = true
+
+	if o.running {
+		o.running = false
+		close(o.tasks)
+
+		o.wait.Wait()
+	}
+}
+
+// fanout sends a message to users and observes a size and a duration of the fan-out.
+// A sharded router passes one task with a part of users to each shard, shards send it in parallel;
+// an unsharded router splits large fan-outs between workers of the pool.
+func (o *Router) fanout(users []*UserInfo, msg *Message) {
+	started := time.Now()
+
+	if o.sharded {
+		parts := make([][]*UserInfo, len(o.shards))
+
+		for _, userInfo := range users {
+			shard := o.shard(userInfo.userId)
+			parts[shard.index] = append(parts[shard.index], userInfo)
+		}
+
+		for index, part := range parts {
+			if len(part) > 0 {
+				o.sendTask(o.shards[index], &routerTask{users: part, msg: msg})
+			}
+		}
+	} else {
+		o.fanoutPool.Send(users, func(userInfo *UserInfo) {
+			o.putMessage(userInfo, msg)
+		})
+	}
+
+	o.statistics.ObserveFanout(msg.typ, len(users), time.Since(started))
+}
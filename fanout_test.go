@@ -0,0 +1,89 @@
+package main
+
+import (
+	"sync/atomic"
+	"testing"
+)
+
+func TestFanoutPool_Parts(t *testing.T) {
+	testSuites := []*struct {
+		workers    int
+		threshold  int
+		run        bool
+		recipients int
+		expected   int
+	}{
+		{workers: 0, threshold: 10, run: true, recipients: 1000, expected: 1},
+		{workers: 4, threshold: 10, run: false, recipients: 1000, expected: 1},
+		{workers: 4, threshold: 10, run: true, recipients: 19, expected: 1},
+		{workers: 4, threshold: 10, run: true, recipients: 20, expected: 2},
+		{workers: 4, threshold: 10, run: true, recipients: 1000, expected: 4},
+		{workers: 4, threshold: 0, run: true, recipients: 3, expected: 3},
+	}
+
+	for i, test := range testSuites {
+		pool := NewFanoutPool(test.workers, test.threshold)
+		if test.run {
+			pool.Run()
+		}
+
+		if exist := pool.parts(test.recipients); exist != test.expected {
+			t.Error(i, ": failed to split a fan-out. Got ", exist, ", but expected is ", test.expected)
+		}
+
+		pool.Shutdown()
+	}
+}
+
+func TestFanoutPool_Send(t *testing.T) {
+	pool := NewFanoutPool(4, 3)
+	pool.Run()
+	defer pool.Shutdown()
+
+	users := []*UserInfo{}
+	for userId := int64(0); userId < 100; userId++ {
+		users = append(users, &UserInfo{userId: userId})
+	}
+
+	sent := make([]int32, len(users))
+
+	pool.Send(users, func(userInfo *UserInfo) {
+		atomic.AddInt32(&sent[userInfo.userId], 1)
+	})
+
+	for userId, exist := range sent {
+		if exist != 1 {
+			t.Error("failed to send a message to #", userId, " once. Got ", exist, ", but expected is ", 1)
+		}
+	}
+}
+
+func TestFanoutPool_SendAfterShutdown(t *testing.T) {
+	pool := NewFanoutPool(4, 1)
+	pool.Run()
+
+	users := []*UserInfo{{userId: 1}, {userId: 2}, {userId: 3}, {userId: 4}}
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 100; i++ {
+			pool.Send(users, func(*UserInfo) {})
+		}
+
+		close(done)
+	}()
+
+	pool.Shutdown()
+	<-done
+
+	var sent int32
+	pool.Send(users, func(*UserInfo) { atomic.AddInt32(&sent, 1) })
+
+	if exist := atomic.LoadInt32(&sent); exist != int32(len(users)) {
+		t.Error("failed to send a fan-out after a shutdown. Got ", exist, ", but expected is ", len(users))
+	}
+
+	// a stopped pool doesn't start again
+	pool.Run()
+	pool.Shutdown()
+}
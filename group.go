@@ -0,0 +1,192 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"github.com/7phs/coding-challenge-queserver/logger"
+)
+
+// group messages are "<sequenceId>|GJ|<userId>|<groupId>", "<sequenceId>|GL|<userId>|<groupId>"
+// and "<sequenceId>|GM|<userId>|<groupId>"
+const (
+	MESSAGE_GROUP_JOIN MessageType = iota + MESSAGE_PRIVATE_MSG + 1
+	MESSAGE_GROUP_LEAVE
+	MESSAGE_GROUP_MSG
+)
+
+func init() {
+	RegisterMessageType(MESSAGE_GROUP_JOIN, &MessageTypeInfo{
+		Code: "GJ", Name: "GroupJoin", Layout: LAYOUT_FROM_TO, Handler: (*Router).handleGroupJoin, State: true,
+	})
+	RegisterMessageType(MESSAGE_GROUP_LEAVE, &MessageTypeInfo{
+		Code: "GL", Name: "GroupLeave", Layout: LAYOUT_FROM_TO, Handler: (*Router).handleGroupLeave, State: true,
+	})
+	RegisterMessageType(MESSAGE_GROUP_MSG, &MessageTypeInfo{
+		Code: "GM", Name: "Group", Layout: LAYOUT_FROM_TO, Handler: (*Router).handleGroupMsg,
+	})
+}
+
+// GroupInfo keeps members of a group, a message of a member is sent to other members
+type GroupInfo struct {
+	groupId int64
+	members sync.Map
+}
+
+func (o *GroupInfo) Join(userId int64) {
+	o.members.Store(userId, true)
+}
+
+func (o *GroupInfo) Leave(userId int64) {
+	o.members.Delete(userId)
+}
+
+func (o *GroupInfo) IsMember(userId int64) bool {
+	_, ok := o.members.Load(userId)
+
+	return ok
+}
+
+func (o *GroupInfo) Range(f func(key, value interface{}) bool) {
+	o.members.Range(f)
+}
+
+func (o *GroupInfo) Members() []int64 {
+	result := []int64{}
+
+	o.members.Range(func(key, _ interface{}) bool {
+		result = append(result, key.(int64))
+
+		return true
+	})
+
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+
+	return result
+}
+
+func (o *UserInfo) JoinGroup(groupId int64) {
+	o.groups.Store(groupId, true)
+}
+
+func (o *UserInfo) LeaveGroup(groupId int64) {
+	o.groups.Delete(groupId)
+}
+
+func (o *UserInfo) RangeGroups(f func(key, value interface{}) bool) {
+	o.groups.Range(f)
+}
+
+func (o *Router) handleGroupJoin(msg *Message) {
+	userInfo := o.getOrAddUserInfo(msg.from)
+	if userInfo != nil {
+		userInfo.JoinGroup(msg.to)
+		o.getOrAddGroupInfo(msg.to).Join(msg.from)
+
+		o.statistics.AddGroupJoin(msg.to)
+	}
+}
+
+func (o *Router) handleGroupLeave(msg *Message) {
+	userInfo := o.getOrAddUserInfo(msg.from)
+	if userInfo != nil {
+		userInfo.LeaveGroup(msg.to)
+
+		if groupInfo, ok := o.groups.Load(msg.to); ok {
+			groupInfo.(*GroupInfo).Leave(msg.from)
+
+			// messages are routed under the lock of the router, nobody joins a group meanwhile
+			if len(groupInfo.(*GroupInfo).Members()) == 0 {
+				o.groups.Delete(msg.to)
+			}
+		}
+
+		o.statistics.AddGroupLeave(msg.to)
+	}
+}
+
+// handleGroupMsg sends a message of a member to other current members, messages of others are rejected
+func (o *Router) handleGroupMsg(msg *Message) {
+	groupInfo, ok := o.groups.Load(msg.to)
+	if !ok || !groupInfo.(*GroupInfo).IsMember(msg.from) {
+		logger.Debug("[ROUTER]: reject message ", msg.payload, " of a non-member ", logger.UserId(msg.from))
+
+		o.statistics.AddGroupRejected(msg.to)
+		return
+	}
+
+	recipients := []*UserInfo{}
+
+	groupInfo.(*GroupInfo).Range(func(key, _ interface{}) bool {
+		if key.(int64) == msg.from {
+			return true
+		}
+
+		userInfo := o.getOrAddUserInfo(key.(int64))
+		if userInfo != nil {
+			recipients = append(recipients, userInfo)
+		}
+
+		return true
+	})
+
+	o.fanout(recipients, msg)
+
+	o.statistics.AddGroupMessage(msg.to, len(recipients))
+}
+
+func (o *Router) getOrAddGroupInfo(groupId int64) *GroupInfo {
+	if groupInfo, ok := o.groups.Load(groupId); ok {
+		return groupInfo.(*GroupInfo)
+	}
+
+	groupInfo, _ := o.groups.LoadOrStore(groupId, &GroupInfo{
+		groupId: groupId,
+	})
+
+	return groupInfo.(*GroupInfo)
+}
+
+// Groups returns ids of groups with members ordered by id
+func (o *Router) Groups() []int64 {
+	result := []int64{}
+
+	o.groups.Range(func(key, _ interface{}) bool {
+		result = append(result, key.(int64))
+
+		return true
+	})
+
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+
+	return result
+}
+
+// GroupMembers returns ids of members of a group ordered by id, an unknown group is reported by false
+func (o *Router) GroupMembers(groupId int64) ([]int64, bool) {
+	groupInfo, ok := o.groups.Load(groupId)
+	if !ok {
+		return nil, false
+	}
+
+	return groupInfo.(*GroupInfo).Members(), true
+}
+
+// UserGroups returns ids of groups joined by a user ordered by id, an unknown user is reported by false
+func (o *Router) UserGroups(userId int64) ([]int64, bool) {
+	userInfo, ok := o.loadUserInfo(userId)
+	if !ok {
+		return nil, false
+	}
+
+	result := []int64{}
+
+	userInfo.RangeGroups(func(key, _ interface{}) bool {
+		result = append(result, key.(int64))
+
+		return true
+	})
+
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+
+	return result, true
+}
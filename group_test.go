@@ -0,0 +1,92 @@
+package main
+
+import (
+	"testing"
+	"reflect"
+)
+
+func TestRouter_Groups(t *testing.T) {
+	statistics := NewStatistics()
+	router := NewRouter(&Config{clientBuffer: 16, mailboxSize: 16}, statistics)
+
+	chs := map[int64]<-chan *Message{}
+	for _, userId := range []int64{1, 2, 3, 4} {
+		chs[userId] = router.RegisterClient(userId, false)
+	}
+
+	for _, payload := range []string{
+		"1|GJ|1|100",
+		"2|GJ|2|100",
+		"3|GJ|3|100",
+		"4|GM|1|100",
+		"5|GM|4|100",
+		"6|GL|2|100",
+		"7|GM|3|100",
+		"8|GM|3|200",
+	} {
+		router.PushMessage(NewMessage(payload))
+	}
+
+	read := func(ch <-chan *Message) []string {
+		result := []string{}
+
+		for len(ch) > 0 {
+			result = append(result, (<-ch).payload)
+		}
+
+		return result
+	}
+
+	expected := map[int64][]string{
+		1: {"7|GM|3|100"},
+		2: {"4|GM|1|100"},
+		3: {"4|GM|1|100"},
+		4: {},
+	}
+
+	for userId, ch := range chs {
+		if exist := read(ch); !reflect.DeepEqual(exist, expected[userId]) {
+			t.Error("failed to route group messages to #", userId, ". Got ", exist, ", but expected is ", expected[userId])
+		}
+	}
+
+	if exist, ok := router.GroupMembers(100); !ok || !reflect.DeepEqual(exist, []int64{1, 3}) {
+		t.Error("failed to list members of a group. Got ", exist, ", but expected is ", []int64{1, 3})
+	}
+
+	if exist, ok := router.UserGroups(2); !ok || len(exist) != 0 {
+		t.Error("failed to remove a left group of a user. Got ", exist)
+	}
+
+	if exist, ok := router.UserGroups(3); !ok || !reflect.DeepEqual(exist, []int64{100}) {
+		t.Error("failed to list groups of a user. Got ", exist, ", but expected is ", []int64{100})
+	}
+
+	if exist := router.Groups(); !reflect.DeepEqual(exist, []int64{100}) {
+		t.Error("failed to list groups. Got ", exist, ", but expected is ", []int64{100})
+	}
+
+	if _, ok := router.getOrAddUserInfo(2).groups.Load(int64(100)); ok {
+		t.Error("failed to remove a group of a user after leaving")
+	}
+
+	expectedCounters := map[int64]GroupCounters{
+		100: {Joined: 3, Left: 1, Messages: 2, Delivered: 3, Rejected: 1},
+		200: {Rejected: 1},
+	}
+
+	if exist := statistics.Groups(); !reflect.DeepEqual(exist, expectedCounters) {
+		t.Error("failed to count group events. Got ", exist, ", but expected is ", expectedCounters)
+	}
+}
+
+func TestRouter_GroupLeaveLast(t *testing.T) {
+	router := NewRouter(&Config{clientBuffer: 16}, NewStatistics())
+
+	router.PushMessage(NewMessage("1|GJ|1|100"))
+	router.PushMessage(NewMessage("2|GL|1|100"))
+
+	if _, ok := router.GroupMembers(100); ok {
+		t.Error("failed to drop a group without members")
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"time"
 	"strings"
 	"net"
 	"errors"
@@ -63,19 +64,26 @@ func ParseAddress(addr, defaultAddr string) (string, error) {
 	return host + ":" + port, nil
 }
 
-func ParseInt64(v string, defaultV int64) int64 {
-	result, err := strconv.ParseInt(v, 10, 64)
-	if err != nil || v == "" {
-		result = defaultV
+func MaxInt64(v, v1 int64) int64 {
+	if v>=v1 {
+		return v
 	}
 
-	return result
+	return v1
 }
 
-func MaxInt64(v, v1 int64) int64 {
+func MinInt64(v, v1 int64) int64 {
+	if v<=v1 {
+		return v
+	}
+
+	return v1
+}
+
+func MaxDuration(v, v1 time.Duration) time.Duration {
 	if v>=v1 {
 		return v
 	}
 
 	return v1
-}
\ No newline at end of file
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestParseAddress(t *testing.T) {
@@ -42,38 +43,56 @@ func TestParseAddress(t *testing.T) {
 	}
 }
 
-func TestParseInt64(t *testing.T) {
-	defaultIn := int64(100)
-
+func TestMaxInt64(t *testing.T) {
 	testSuites := []*struct {
-		in       string
+		in1      int64
+		in2      int64
 		expected int64
 	}{
-		{in: "12938721897", expected: 12938721897},
-		{in: "asdsa721897", expected: defaultIn},
+		{in1: 300, in2: 600, expected: 600},
+		{in1: 900, in2: 100, expected: 900},
+		{in1: 1900, in2: 1900, expected: 1900},
 	}
 
 	for _, test := range testSuites {
-		exist := ParseInt64(test.in, defaultIn)
+		exist := MaxInt64(test.in1, test.in2)
 		if exist != test.expected {
-			t.Error("failed to parse '", test.in, "' to int64. Got ", exist, ", but expected is ", test.expected)
+			t.Error("failed to get maximum from ", test.in1, " and ", test.in2, ". Got ", exist, ", but expected is ", test.expected)
 		}
 	}
 }
 
-func TestMaxInt64(t *testing.T) {
+func TestMinInt64(t *testing.T) {
 	testSuites := []*struct {
 		in1      int64
 		in2      int64
 		expected int64
 	}{
-		{in1: 300, in2: 600, expected: 600},
-		{in1: 900, in2: 100, expected: 900},
+		{in1: 300, in2: 600, expected: 300},
+		{in1: 900, in2: 100, expected: 100},
 		{in1: 1900, in2: 1900, expected: 1900},
 	}
 
 	for _, test := range testSuites {
-		exist := MaxInt64(test.in1, test.in2)
+		exist := MinInt64(test.in1, test.in2)
+		if exist != test.expected {
+			t.Error("failed to get minimum from ", test.in1, " and ", test.in2, ". Got ", exist, ", but expected is ", test.expected)
+		}
+	}
+}
+
+func TestMaxDuration(t *testing.T) {
+	testSuites := []*struct {
+		in1      time.Duration
+		in2      time.Duration
+		expected time.Duration
+	}{
+		{in1: time.Second, in2: time.Minute, expected: time.Minute},
+		{in1: time.Hour, in2: time.Millisecond, expected: time.Hour},
+	}
+
+	for _, test := range testSuites {
+		exist := MaxDuration(test.in1, test.in2)
 		if exist != test.expected {
 			t.Error("failed to get maximum from ", test.in1, " and ", test.in2, ". Got ", exist, ", but expected is ", test.expected)
 		}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"io"
+	"fmt"
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	HISTOGRAM_LATENCY_BUCKETS = []time.Duration{
+		100 * time.Microsecond,
+		time.Millisecond,
+		5 * time.Millisecond,
+		10 * time.Millisecond,
+		50 * time.Millisecond,
+		100 * time.Millisecond,
+		500 * time.Millisecond,
+		time.Second,
+		5 * time.Second,
+	}
+
+	HISTOGRAM_FANOUT_BUCKETS = []int64{1, 10, 100, 1000, 10000, 100000}
+)
+
+// Histogram counts durations or sizes by upper bounds of buckets without locking
+type Histogram struct {
+	bounds []time.Duration
+	// a unit of values in the Prometheus format, seconds for durations and one for sizes
+	unit time.Duration
+	// the last bucket counts durations over all bounds
+	counts []uint64
+	count  uint64
+	sum    int64
+}
+
+func NewHistogram(bounds []time.Duration) *Histogram {
+	return &Histogram{
+		bounds: bounds,
+		unit:   time.Second,
+		counts: make([]uint64, len(bounds)+1),
+	}
+}
+
+// NewSizeHistogram counts sizes, they are observed by ObserveSize
+func NewSizeHistogram(bounds []int64) *Histogram {
+	histogram := &Histogram{
+		unit:   1,
+		counts: make([]uint64, len(bounds)+1),
+	}
+
+	for _, bound := range bounds {
+		histogram.bounds = append(histogram.bounds, time.Duration(bound))
+	}
+
+	return histogram
+}
+
+func (o *Histogram) Observe(d time.Duration) {
+	i := 0
+	for i < len(o.bounds) && d > o.bounds[i] {
+		i++
+	}
+
+	atomic.AddUint64(&o.counts[i], 1)
+	atomic.AddUint64(&o.count, 1)
+	atomic.AddInt64(&o.sum, int64(d))
+}
+
+func (o *Histogram) ObserveSize(size int) {
+	o.Observe(time.Duration(size))
+}
+
+func (o *Histogram) Count() uint64 {
+	return atomic.LoadUint64(&o.count)
+}
+
+func (o *Histogram) Sum() time.Duration {
+	return time.Duration(atomic.LoadInt64(&o.sum))
+}
+
+func (o *Histogram) Mean() time.Duration {
+	count := o.Count()
+	if count == 0 {
+		return 0
+	}
+
+	return o.Sum() / time.Duration(count)
+}
+
+// Quantile returns an upper bound of a bucket of the quantile, durations over all bounds are reported by the last bound
+func (o *Histogram) Quantile(q float64) time.Duration {
+	count := o.Count()
+	if count == 0 || len(o.bounds) == 0 {
+		return 0
+	}
+
+	rank := uint64(math.Ceil(q * float64(count)))
+	cumulative := uint64(0)
+
+	for i, bound := range o.bounds {
+		cumulative += atomic.LoadUint64(&o.counts[i])
+		if cumulative >= rank {
+			return bound
+		}
+	}
+
+	return o.bounds[len(o.bounds)-1]
+}
+
+// WritePrometheus writes the histogram in the Prometheus text format in seconds or in sizes.
+// Labels are a list of pairs "name=\"value\"" joined by a comma, it can be empty.
+func (o *Histogram) WritePrometheus(w io.Writer, name string, labels string) {
+	prefix := ""
+	if labels != "" {
+		prefix = labels + ","
+	}
+
+	cumulative := uint64(0)
+	for i, bound := range o.bounds {
+		cumulative += atomic.LoadUint64(&o.counts[i])
+
+		fmt.Fprintf(w, "%s_bucket{%sle=\"%g\"} %d\n", name, prefix, o.inUnits(bound), cumulative)
+	}
+
+	// counters are read one by one, the total is summed up to keep buckets consistent
+	cumulative += atomic.LoadUint64(&o.counts[len(o.bounds)])
+
+	fmt.Fprintf(w, "%s_bucket{%sle=\"+Inf\"} %d\n", name, prefix, cumulative)
+
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+
+	fmt.Fprintf(w, "%s_sum%s %g\n", name, labels, o.inUnits(o.Sum()))
+	fmt.Fprintf(w, "%s_count%s %d\n", name, labels, cumulative)
+}
+
+func (o *Histogram) inUnits(v time.Duration) float64 {
+	if o.unit == time.Second {
+		return v.Seconds()
+	}
+
+	return float64(v) / float64(o.unit)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestHistogram(t *testing.T) {
+	histogram := NewHistogram([]time.Duration{time.Millisecond, time.Second})
+
+	for _, d := range []time.Duration{time.Microsecond, time.Millisecond, 2 * time.Millisecond, 2 * time.Second} {
+		histogram.Observe(d)
+	}
+
+	if exist := histogram.Count(); exist != 4 {
+		t.Error("failed to count observations. Got ", exist, ", but expected is ", 4)
+	}
+
+	if exist, expected := histogram.Mean(), (2*time.Second+3*time.Millisecond+time.Microsecond)/4; exist != expected {
+		t.Error("failed to calc a mean. Got ", exist, ", but expected is ", expected)
+	}
+
+	testSuites := []*struct {
+		q        float64
+		expected time.Duration
+	}{
+		{q: 0.1, expected: time.Millisecond},
+		{q: 0.5, expected: time.Millisecond},
+		{q: 0.6, expected: time.Second},
+		{q: 0.99, expected: time.Second},
+	}
+
+	for _, test := range testSuites {
+		if exist := histogram.Quantile(test.q); exist != test.expected {
+			t.Error("failed to calc a quantile ", test.q, ". Got ", exist, ", but expected is ", test.expected)
+		}
+	}
+
+	line := bytes.NewBuffer(nil)
+	histogram.WritePrometheus(line, "test_seconds", "type=\"B\"")
+
+	expected := "test_seconds_bucket{type=\"B\",le=\"0.001\"} 2\n" +
+		"test_seconds_bucket{type=\"B\",le=\"1\"} 3\n" +
+		"test_seconds_bucket{type=\"B\",le=\"+Inf\"} 4\n" +
+		"test_seconds_sum{type=\"B\"} 2.003001\n" +
+		"test_seconds_count{type=\"B\"} 4\n"
+
+	if exist := line.String(); exist != expected {
+		t.Error("failed to write a histogram. Got '", exist, "', but expected is '", expected, "'")
+	}
+}
+
+func TestNewSizeHistogram(t *testing.T) {
+	histogram := NewSizeHistogram([]int64{1, 10})
+
+	for _, size := range []int{1, 5, 20} {
+		histogram.ObserveSize(size)
+	}
+
+	if exist := histogram.Quantile(0.5); exist != 10 {
+		t.Error("failed to calc a quantile of sizes. Got ", int64(exist), ", but expected is ", 10)
+	}
+
+	line := bytes.NewBuffer(nil)
+	histogram.WritePrometheus(line, "test_recipients", "type=\"S\"")
+
+	expected := "test_recipients_bucket{type=\"S\",le=\"1\"} 1\n" +
+		"test_recipients_bucket{type=\"S\",le=\"10\"} 2\n" +
+		"test_recipients_bucket{type=\"S\",le=\"+Inf\"} 3\n" +
+		"test_recipients_sum{type=\"S\"} 26\n" +
+		"test_recipients_count{type=\"S\"} 3\n"
+
+	if exist := line.String(); exist != expected {
+		t.Error("failed to write a histogram of sizes. Got '", exist, "', but expected is '", expected, "'")
+	}
+}
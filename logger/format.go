@@ -0,0 +1,166 @@
+package logger
+
+import (
+	"io"
+	"os"
+	"log"
+	"fmt"
+	"sync"
+	"time"
+	"bytes"
+	"strings"
+	"sync/atomic"
+	"encoding/json"
+)
+
+const (
+	// free-form lines of the standard log package
+	FORMAT_TEXT = iota + 1
+	// one JSON object per line with a timestamp, a level, a component, a message and fields
+	FORMAT_JSON
+)
+
+const (
+	USER_ID_FIELD     = "userId"
+	SEQUENCE_ID_FIELD = "sequenceId"
+)
+
+var (
+	logFormat = int32(FORMAT_TEXT)
+
+	jsonOutput     io.Writer = os.Stderr
+	jsonOutputLock sync.Mutex
+)
+
+// Field is a typed value of a log line. It is written as a value in the text format
+// and as a separate key in the JSON format.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+func NewField(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+func UserId(userId int64) Field {
+	return NewField(USER_ID_FIELD, userId)
+}
+
+func SequenceId(sequenceId int64) Field {
+	return NewField(SEQUENCE_ID_FIELD, sequenceId)
+}
+
+func (o Field) String() string {
+	return fmt.Sprint(o.Value)
+}
+
+// Parse a string with a format name
+func ParseFormat(format string, defaultFormat int) int {
+	switch strings.ToLower(format) {
+	case "text":
+		return FORMAT_TEXT
+	case "json":
+		return FORMAT_JSON
+	}
+
+	return defaultFormat
+}
+
+func FormatToString(format int) string {
+	switch format {
+	case FORMAT_TEXT:
+		return "Text"
+	case FORMAT_JSON:
+		return "Json"
+	}
+
+	return "Unknown"
+}
+
+// set an output format, it is safe to change it on a running server
+func SetFormat(format int) {
+	atomic.StoreInt32(&logFormat, int32(format))
+}
+
+func Format() int {
+	return int(atomic.LoadInt32(&logFormat))
+}
+
+// set an output of the JSON format, the text format uses an output of the standard log package
+func SetJsonOutput(w io.Writer) {
+	jsonOutputLock.Lock()
+	defer jsonOutputLock.Unlock()
+
+	jsonOutput = w
+}
+
+func output(level int, msgs []interface{}) {
+	if Format() != FORMAT_JSON {
+		log.Println(msgs...)
+		return
+	}
+
+	line := formatJson(time.Now(), level, msgs)
+
+	jsonOutputLock.Lock()
+	defer jsonOutputLock.Unlock()
+
+	jsonOutput.Write(line)
+}
+
+// splitComponent takes a component from a prefix "[COMPONENT]: " of the first message
+func splitComponent(msgs []interface{}) (string, []interface{}) {
+	if len(msgs) > 0 {
+		if first, ok := msgs[0].(string); ok && strings.HasPrefix(first, "[") {
+			if end := strings.Index(first, "]:"); end > 0 {
+				return first[1:end], append([]interface{}{strings.TrimLeft(first[end+2:], " ")}, msgs[1:]...)
+			}
+		}
+	}
+
+	return "", msgs
+}
+
+func formatJson(now time.Time, level int, msgs []interface{}) []byte {
+	component, msgs := splitComponent(msgs)
+
+	line := bytes.NewBufferString("{")
+
+	writeValue := func(key string, value interface{}) {
+		if line.Len() > 1 {
+			line.WriteString(",")
+		}
+
+		encodedKey, _ := json.Marshal(key)
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			encoded, _ = json.Marshal(fmt.Sprint(value))
+		}
+
+		line.Write(encodedKey)
+		line.WriteString(":")
+		line.Write(encoded)
+	}
+
+	writeValue("time", now.Format(time.RFC3339Nano))
+	writeValue("level", strings.ToLower(LevelToString(level)))
+	if component != "" {
+		writeValue("component", component)
+	}
+	writeValue("message", strings.TrimSpace(fmt.Sprint(msgs...)))
+
+	for _, msg := range msgs {
+		if field, ok := msg.(Field); ok {
+			if err, ok := field.Value.(error); ok {
+				writeValue(field.Key, err.Error())
+			} else {
+				writeValue(field.Key, field.Value)
+			}
+		}
+	}
+
+	line.WriteString("}\n")
+
+	return line.Bytes()
+}
@@ -0,0 +1,76 @@
+package logger
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseFormat(t *testing.T) {
+	testSuites := []*struct {
+		in       string
+		expected int
+	}{
+		{in: "Text", expected: FORMAT_TEXT},
+		{in: "JSON", expected: FORMAT_JSON},
+		{in: "xml", expected: FORMAT_TEXT},
+		{expected: FORMAT_TEXT},
+	}
+
+	for _, test := range testSuites {
+		if exist := ParseFormat(test.in, FORMAT_TEXT); exist != test.expected {
+			t.Error("failed to parse format '", test.in, "'. Got ", exist, ", but expected is ", test.expected)
+		}
+	}
+}
+
+func TestFormatJson(t *testing.T) {
+	now := time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	testSuites := []*struct {
+		level    int
+		msgs     []interface{}
+		expected string
+	}{
+		{
+			level:    INFO,
+			msgs:     []interface{}{"[ROUTER]: register client, user id #", UserId(50), ", acknowledgements ", true},
+			expected: `{"time":"2018-01-02T03:04:05Z","level":"info","component":"ROUTER","message":"register client, user id #50, acknowledgements true","userId":50}` + "\n",
+		},
+		{
+			level:    WARNING,
+			msgs:     []interface{}{"[CLIENT]: #", UserId(7), ", failed ", NewField("error", errors.New("broken \"pipe\""))},
+			expected: `{"time":"2018-01-02T03:04:05Z","level":"warning","component":"CLIENT","message":"#7, failed broken \"pipe\"","userId":7,"error":"broken \"pipe\""}` + "\n",
+		},
+		{
+			level:    DEBUG,
+			msgs:     []interface{}{"no component ", SequenceId(3)},
+			expected: `{"time":"2018-01-02T03:04:05Z","level":"debug","message":"no component 3","sequenceId":3}` + "\n",
+		},
+	}
+
+	for _, test := range testSuites {
+		if exist := string(formatJson(now, test.level, test.msgs)); exist != test.expected {
+			t.Error("failed to format a line. Got '", exist, "', but expected is '", test.expected, "'")
+		}
+	}
+}
+
+func TestSetFormat(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+
+	SetJsonOutput(buf)
+	SetFormat(FORMAT_JSON)
+	defer SetFormat(FORMAT_TEXT)
+
+	prevFlags := Flags()
+	SetFlags(ALL)
+	defer SetFlags(prevFlags)
+
+	Info("[QUEUE]: drain ", 10, " messages")
+
+	if exist := buf.String(); !bytes.Contains(buf.Bytes(), []byte(`"component":"QUEUE","message":"drain 10 messages"`)) {
+		t.Error("failed to write a JSON line. Got '", exist, "'")
+	}
+}
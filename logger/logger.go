@@ -1,8 +1,8 @@
 package logger
 
 import (
-	"log"
 	"strings"
+	"sync/atomic"
 )
 
 const (
@@ -15,7 +15,7 @@ const (
 )
 
 var (
-	logFlags = ALL
+	logFlags = int32(ALL)
 )
 
 // Parse a string with log level name
@@ -76,39 +76,43 @@ func LevelToString(level int) string {
 	return "Unknown"
 }
 
-// set logging flags, but only before start a server to prevent sync problems
+// set logging flags, it is safe to change them on a running server
 func SetFlags(flags int) {
-	logFlags = flags
+	atomic.StoreInt32(&logFlags, int32(flags))
+}
+
+func Flags() int {
+	return int(atomic.LoadInt32(&logFlags))
 }
 
 func Debug(msgs ... interface{}) {
-	if logFlags&DEBUG==0 {
+	if !enabled(DEBUG, msgs) || !sampled(DEBUG, 1) {
 		return
 	}
 
-	log.Println(msgs...)
+	output(DEBUG, msgs)
 }
 
 func Info(msgs ... interface{}) {
-	if logFlags&INFO==0 {
+	if !enabled(INFO, msgs) || !sampled(INFO, 1) {
 		return
 	}
 
-	log.Println(msgs...)
+	output(INFO, msgs)
 }
 
 func Warning(msgs ... interface{}) {
-	if logFlags&WARNING==0 {
+	if !enabled(WARNING, msgs) || !sampled(WARNING, 1) {
 		return
 	}
 
-	log.Println(msgs...)
+	output(WARNING, msgs)
 }
 
 func Error(msgs ... interface{}) {
-	if logFlags&ERROR==0 {
+	if !enabled(ERROR, msgs) || !sampled(ERROR, 1) {
 		return
 	}
 
-	log.Println(msgs...)
+	output(ERROR, msgs)
 }
@@ -0,0 +1,139 @@
+package logger
+
+import (
+	"sync"
+	"strings"
+	"sync/atomic"
+)
+
+// overrides of logging flags are replaced as a whole by writers, readers load them without locking
+type overrides struct {
+	components map[string]int
+	users      map[int64]int
+}
+
+var (
+	// *overrides, nil without overrides
+	logOverrides     atomic.Value
+	logOverridesLock sync.Mutex
+)
+
+func init() {
+	logOverrides.Store((*overrides)(nil))
+}
+
+func loadOverrides() *overrides {
+	return logOverrides.Load().(*overrides)
+}
+
+// update copies overrides, changes and stores them
+func updateOverrides(change func(*overrides)) {
+	logOverridesLock.Lock()
+	defer logOverridesLock.Unlock()
+
+	next := &overrides{
+		components: make(map[string]int),
+		users:      make(map[int64]int),
+	}
+
+	if prev := loadOverrides(); prev != nil {
+		for k, v := range prev.components {
+			next.components[k] = v
+		}
+		for k, v := range prev.users {
+			next.users[k] = v
+		}
+	}
+
+	change(next)
+
+	if len(next.components) == 0 && len(next.users) == 0 {
+		next = nil
+	}
+
+	logOverrides.Store(next)
+}
+
+// SetOverrides replaces all overrides of logging flags by components and by user ids
+func SetOverrides(components map[string]int, users map[int64]int) {
+	updateOverrides(func(o *overrides) {
+		o.components = make(map[string]int)
+		for k, v := range components {
+			o.components[strings.ToUpper(k)] = v
+		}
+
+		o.users = make(map[int64]int)
+		for k, v := range users {
+			o.users[k] = v
+		}
+	})
+}
+
+// SetComponentLevel overrides logging flags of lines with a prefix "[COMPONENT]: "
+func SetComponentLevel(component string, flags int) {
+	updateOverrides(func(o *overrides) {
+		o.components[strings.ToUpper(component)] = flags
+	})
+}
+
+func ClearComponentLevel(component string) {
+	updateOverrides(func(o *overrides) {
+		delete(o.components, strings.ToUpper(component))
+	})
+}
+
+// SetUserLevel overrides logging flags of lines with a field UserId, it takes precedence over a component
+func SetUserLevel(userId int64, flags int) {
+	updateOverrides(func(o *overrides) {
+		o.users[userId] = flags
+	})
+}
+
+func ClearUserLevel(userId int64) {
+	updateOverrides(func(o *overrides) {
+		delete(o.users, userId)
+	})
+}
+
+// Overrides returns copies of overrides by components and by user ids
+func Overrides() (map[string]int, map[int64]int) {
+	components := make(map[string]int)
+	users := make(map[int64]int)
+
+	if current := loadOverrides(); current != nil {
+		for k, v := range current.components {
+			components[k] = v
+		}
+		for k, v := range current.users {
+			users[k] = v
+		}
+	}
+
+	return components, users
+}
+
+// enabled checks a level of a line: a user override goes first, then a component override, then global flags
+func enabled(level int, msgs []interface{}) bool {
+	current := loadOverrides()
+	if current == nil {
+		return Flags()&level != 0
+	}
+
+	for _, msg := range msgs {
+		if field, ok := msg.(Field); ok && field.Key == USER_ID_FIELD {
+			if userId, ok := field.Value.(int64); ok {
+				if flags, ok := current.users[userId]; ok {
+					return flags&level != 0
+				}
+			}
+		}
+	}
+
+	if component, _ := splitComponent(msgs); component != "" {
+		if flags, ok := current.components[component]; ok {
+			return flags&level != 0
+		}
+	}
+
+	return Flags()&level != 0
+}
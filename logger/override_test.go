@@ -0,0 +1,48 @@
+package logger
+
+import (
+	"testing"
+)
+
+func TestEnabled(t *testing.T) {
+	prevFlags := Flags()
+	SetFlags(CalcLevel(WARNING))
+	defer SetFlags(prevFlags)
+
+	SetOverrides(map[string]int{"client": CalcLevel(DEBUG), "ROUTER": CalcLevel(ERROR)}, nil)
+	SetUserLevel(1001, CalcLevel(DEBUG))
+	defer SetOverrides(nil, nil)
+
+	testSuites := []*struct {
+		level    int
+		msgs     []interface{}
+		expected bool
+	}{
+		{level: DEBUG, msgs: []interface{}{"[QUEUE]: push message"}, expected: false},
+		{level: WARNING, msgs: []interface{}{"[QUEUE]: drop a late message"}, expected: true},
+		{level: DEBUG, msgs: []interface{}{"[CLIENT]: #", UserId(5), " write"}, expected: true},
+		{level: WARNING, msgs: []interface{}{"[ROUTER]: disconnect a slow client, user id #", UserId(5)}, expected: false},
+		// a user goes before a component
+		{level: DEBUG, msgs: []interface{}{"[ROUTER]: send message -> ", UserId(1001)}, expected: true},
+		{level: DEBUG, msgs: []interface{}{"without a component"}, expected: false},
+	}
+
+	for i, test := range testSuites {
+		if exist := enabled(test.level, test.msgs); exist != test.expected {
+			t.Error(i, ": failed to check a level of ", test.msgs, ". Got ", exist, ", but expected is ", test.expected)
+		}
+	}
+
+	ClearUserLevel(1001)
+	ClearComponentLevel("ROUTER")
+
+	components, users := Overrides()
+	if len(components) != 1 || components["CLIENT"] != CalcLevel(DEBUG) || len(users) != 0 {
+		t.Error("failed to clear overrides. Got ", components, " and ", users)
+	}
+
+	ClearComponentLevel("CLIENT")
+	if loadOverrides() != nil {
+		t.Error("failed to switch to the fast path without overrides")
+	}
+}
@@ -0,0 +1,115 @@
+package logger
+
+import (
+	"sync"
+	"time"
+	"strconv"
+	"runtime"
+	"path/filepath"
+	"sync/atomic"
+)
+
+const (
+	SAMPLING_INTERVAL = time.Second
+)
+
+// sampling passes first lines of a call site in each interval, then every n-th line.
+// Errors are never sampled.
+type sampling struct {
+	first uint64
+	every uint64
+}
+
+// samplingSite counts lines of a call site in the current interval
+type samplingSite struct {
+	level    int
+	location string
+
+	interval   int64
+	count      uint64
+	suppressed uint64
+}
+
+var (
+	// *sampling, nil without sampling
+	logSampling     atomic.Value
+	logSamplingOnce sync.Once
+	// a program counter of a call site -> *samplingSite
+	samplingSites sync.Map
+)
+
+func init() {
+	logSampling.Store((*sampling)(nil))
+}
+
+// SetSampling enables sampling of lines of each call site: first lines in a second pass,
+// then every n-th line. Suppressed lines are counted and reported every second. Zero first disables it.
+func SetSampling(first int, every int) {
+	if first <= 0 {
+		logSampling.Store((*sampling)(nil))
+		return
+	}
+
+	logSampling.Store(&sampling{
+		first: uint64(first),
+		every: uint64(every),
+	})
+
+	logSamplingOnce.Do(func() {
+		go func() {
+			for range time.Tick(SAMPLING_INTERVAL) {
+				flushSampling()
+			}
+		}()
+	})
+}
+
+// sampled checks a line of a call site skipping frames of the logger
+func sampled(level int, skip int) bool {
+	current := logSampling.Load().(*sampling)
+	if current == nil || level == ERROR {
+		return true
+	}
+
+	pc, file, line, ok := runtime.Caller(skip + 1)
+	if !ok {
+		return true
+	}
+
+	value, ok := samplingSites.Load(pc)
+	if !ok {
+		value, _ = samplingSites.LoadOrStore(pc, &samplingSite{
+			level:    level,
+			location: filepath.Base(file) + ":" + strconv.Itoa(line),
+		})
+	}
+
+	site := value.(*samplingSite)
+
+	now := time.Now().UnixNano() / int64(SAMPLING_INTERVAL)
+	if prev := atomic.LoadInt64(&site.interval); prev != now && atomic.CompareAndSwapInt64(&site.interval, prev, now) {
+		atomic.StoreUint64(&site.count, 0)
+	}
+
+	n := atomic.AddUint64(&site.count, 1)
+	if n <= current.first || current.every > 0 && (n-current.first)%current.every == 0 {
+		return true
+	}
+
+	atomic.AddUint64(&site.suppressed, 1)
+
+	return false
+}
+
+// flushSampling reports suppressed lines by call sites
+func flushSampling() {
+	samplingSites.Range(func(_, value interface{}) bool {
+		site := value.(*samplingSite)
+
+		if suppressed := atomic.SwapUint64(&site.suppressed, 0); suppressed > 0 {
+			output(site.level, []interface{}{"[LOGGER]: suppressed ", suppressed, " lines at ", site.location})
+		}
+
+		return true
+	})
+}
@@ -0,0 +1,69 @@
+package logger
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestSampling(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+
+	SetJsonOutput(buf)
+	SetFormat(FORMAT_JSON)
+	defer SetFormat(FORMAT_TEXT)
+
+	prevFlags := Flags()
+	SetFlags(ALL)
+	defer SetFlags(prevFlags)
+
+	SetSampling(3, 4)
+	defer SetSampling(0, 0)
+
+	for i := 0; i < 20; i++ {
+		Debug("[QUEUE]: push message ", i)
+		// errors are never sampled
+		Error("[QUEUE]: failed ", i)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+
+	debug, errors := []string{}, 0
+	for _, line := range lines {
+		switch {
+		case strings.Contains(line, `"level":"debug"`):
+			debug = append(debug, line[strings.Index(line, `"message"`):])
+		case strings.Contains(line, `"level":"error"`):
+			errors++
+		}
+	}
+
+	// the first 3 lines, then every 4th line: 7, 11, 15, 19
+	expected := []string{
+		`"message":"push message 0"}`, `"message":"push message 1"}`, `"message":"push message 2"}`,
+		`"message":"push message 6"}`, `"message":"push message 10"}`, `"message":"push message 14"}`,
+		`"message":"push message 18"}`,
+	}
+
+	if strings.Join(debug, "\n") != strings.Join(expected, "\n") {
+		t.Error("failed to sample lines. Got ", debug, ", but expected is ", expected)
+	}
+
+	if errors != 20 {
+		t.Error("failed to keep all errors. Got ", errors, ", but expected is ", 20)
+	}
+
+	buf.Reset()
+	flushSampling()
+
+	if exist := buf.String(); !strings.Contains(exist, `"component":"LOGGER","message":"suppressed 13 lines at sampling_test.go:`) {
+		t.Error("failed to report suppressed lines. Got '", exist, "'")
+	}
+
+	buf.Reset()
+	flushSampling()
+
+	if exist := buf.String(); exist != "" {
+		t.Error("failed to reset suppressed lines. Got '", exist, "'")
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+type mailboxItem struct {
+	msg    *Message
+	stored time.Time
+}
+
+// Mailbox keeps messages of an offline user or a history of a user bounded by a count and an age of messages.
+// It is not synchronized, an owner has to lock it.
+type Mailbox struct {
+	size  int
+	ttl   time.Duration
+	items []*mailboxItem
+}
+
+func NewMailbox(size int, ttl time.Duration) *Mailbox {
+	return &Mailbox{
+		size: size,
+		ttl:  ttl,
+	}
+}
+
+func (o *Mailbox) Len() int {
+	return len(o.items)
+}
+
+// Resize changes limits and drops messages over them
+func (o *Mailbox) Resize(size int, ttl time.Duration) {
+	o.size = size
+	o.ttl = ttl
+
+	o.expire(time.Now())
+
+	switch {
+	case size <= 0:
+		o.items = nil
+	case len(o.items) > size:
+		o.items = o.items[len(o.items)-size:]
+	}
+}
+
+// Put stores a message and drops the oldest one over the size limit
+func (o *Mailbox) Put(msg *Message) {
+	if o.size <= 0 {
+		return
+	}
+
+	o.expire(time.Now())
+
+	if len(o.items) >= o.size {
+		o.items = o.items[len(o.items)-o.size+1:]
+	}
+
+	o.items = append(o.items, &mailboxItem{
+		msg:    msg,
+		stored: time.Now(),
+	})
+}
+
+// Take removes all messages from the mailbox and returns not expired ones ordered by sequenceId
+func (o *Mailbox) Take() []*Message {
+	o.expire(time.Now())
+
+	result := make([]*Message, 0, len(o.items))
+	for _, item := range o.items {
+		result = append(result, item.msg)
+	}
+
+	o.items = nil
+
+	sort.SliceStable(result, func(i, j int) bool { return result[i].sequenceId < result[j].sequenceId })
+
+	return result
+}
+
+// After returns not expired messages with sequenceId greater than the given one without removing them
+func (o *Mailbox) After(sequenceId int64) []*Message {
+	o.expire(time.Now())
+
+	result := []*Message{}
+	for _, item := range o.items {
+		if item.msg.sequenceId > sequenceId {
+			result = append(result, item.msg)
+		}
+	}
+
+	sort.SliceStable(result, func(i, j int) bool { return result[i].sequenceId < result[j].sequenceId })
+
+	return result
+}
+
+func (o *Mailbox) expire(now time.Time) {
+	if o.ttl <= 0 {
+		return
+	}
+
+	limit := now.Add(-o.ttl)
+
+	i := 0
+	for i < len(o.items) && o.items[i].stored.Before(limit) {
+		i++
+	}
+
+	o.items = o.items[i:]
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"testing"
+	"reflect"
+	"time"
+)
+
+func mailboxSequencesId(msgs []*Message) []int64 {
+	result := []int64{}
+	for _, msg := range msgs {
+		result = append(result, msg.sequenceId)
+	}
+
+	return result
+}
+
+func TestMailbox(t *testing.T) {
+	mailbox := NewMailbox(3, time.Hour)
+
+	for _, sequenceId := range []int64{5, 1, 4, 3} {
+		mailbox.Put(&Message{sequenceId: sequenceId})
+	}
+
+	if exist := mailbox.Len(); exist != 3 {
+		t.Error("failed to limit a mailbox size. Got ", exist, ", but expected is ", 3)
+	}
+
+	expected := []int64{1, 3, 4}
+	if exist := mailboxSequencesId(mailbox.Take()); !reflect.DeepEqual(exist, expected) {
+		t.Error("failed to take messages in order. Got ", exist, ", but expected is ", expected)
+	}
+
+	if exist := mailbox.Len(); exist != 0 {
+		t.Error("failed to clean a mailbox. Got ", exist, ", but expected is ", 0)
+	}
+}
+
+func TestMailbox_TTL(t *testing.T) {
+	mailbox := NewMailbox(10, 20*time.Millisecond)
+
+	mailbox.Put(&Message{sequenceId: 1})
+	time.Sleep(40 * time.Millisecond)
+	mailbox.Put(&Message{sequenceId: 2})
+
+	expected := []int64{2}
+	if exist := mailboxSequencesId(mailbox.Take()); !reflect.DeepEqual(exist, expected) {
+		t.Error("failed to expire old messages. Got ", exist, ", but expected is ", expected)
+	}
+}
+
+func TestMailbox_Disabled(t *testing.T) {
+	mailbox := NewMailbox(0, time.Hour)
+
+	mailbox.Put(&Message{sequenceId: 1})
+
+	if exist := mailbox.Len(); exist != 0 {
+		t.Error("failed to skip messages by a disabled mailbox. Got ", exist, ", but expected is ", 0)
+	}
+}
+
+func TestMailbox_After(t *testing.T) {
+	mailbox := NewMailbox(10, time.Hour)
+
+	for _, sequenceId := range []int64{5, 1, 4, 3} {
+		mailbox.Put(&Message{sequenceId: sequenceId})
+	}
+
+	expected := []int64{4, 5}
+	if exist := mailboxSequencesId(mailbox.After(3)); !reflect.DeepEqual(exist, expected) {
+		t.Error("failed to get messages after a sequenceId. Got ", exist, ", but expected is ", expected)
+	}
+
+	if exist := mailbox.Len(); exist != 4 {
+		t.Error("failed to keep messages. Got ", exist, ", but expected is ", 4)
+	}
+}
+
+func TestMailbox_Resize(t *testing.T) {
+	mailbox := NewMailbox(5, time.Minute)
+
+	for i := int64(1); i <= 5; i++ {
+		mailbox.Put(&Message{sequenceId: i})
+	}
+
+	mailbox.Resize(2, time.Minute)
+
+	expected := []int64{4, 5}
+	if exist := mailboxSequencesId(mailbox.After(0)); !reflect.DeepEqual(exist, expected) {
+		t.Error("failed to drop messages over a new size. Got ", exist, ", but expected is ", expected)
+	}
+
+	mailbox.Resize(0, time.Minute)
+	if exist := mailbox.Len(); exist != 0 {
+		t.Error("failed to disable a mailbox. Got ", exist, ", but expected is ", 0)
+	}
+}
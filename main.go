@@ -4,6 +4,7 @@ import (
 	"sync"
 	"os/signal"
 	"os"
+	"syscall"
 	"github.com/7phs/coding-challenge-queserver/logger"
 )
 
@@ -33,30 +34,81 @@ func main() {
 
 	logger.Info("[SOUNDSERVER]: starting")
 	logger.Info("[SOUNDSERVER]: read config")
-	config, err := ParseConfig()
+	config, err := ParseConfig(os.Args[1:])
 	if err != nil {
 		logger.Error("[SOUNDSERVER]: failed to get configuration parameters: ", err)
+		os.Exit(2)
+	}
+
+	if config.PrintConfig() {
+		config.Print(os.Stdout)
 		return
 	}
 
 	logger.SetFlags(config.LogLevel())
+	logger.SetFormat(config.LogFormat())
+	logger.SetOverrides(config.LogComponents(), config.LogUsers())
+	logger.SetSampling(config.LogSampleFirst(), config.LogSampleEvery())
 
-	logger.Info("[SOUNDSERVER]: config parameters - ",
-		"EVENT_SOURCE=", config.EventSource(),
-		"; CLIENT=", config.Client(),
-		"; QUEUE_LIMIT=", config.QueueLimit(),
-		"; QUEUE_TTL=", config.QueueTTL(),
-		"; LOG_LEVEL=", logger.LevelToString(config.LogLevel()))
+	logger.Info("[SOUNDSERVER]: config parameters - ", config.String())
 
 	logger.Info("[SOUNDSERVER]: create a statistics")
 	statistics := NewStatistics()
 	shutdownQueue.Add(statistics)
 
 	logger.Info("[SOUNDSERVER]: create a router")
-	router := NewRouter()
+	router := NewRouter(config, statistics)
+
+	// the log is shut down after the snapshotter releasing messages of the last snapshot in it
+	var wal *Wal
+	if config.WalDir() != "" {
+		logger.Info("[SOUNDSERVER]: open a write-ahead log")
+		wal, err = NewWal(config)
+		if err != nil {
+			logger.Error("[SOUNDSERVER]: failed to open a write-ahead log: ", err)
+
+			shutdownQueue.Shutdown()
+			return
+		}
+		shutdownQueue.Add(wal)
+	}
+
+	var snapshotter *Snapshotter
+	if config.SnapshotPath() != "" {
+		logger.Info("[SOUNDSERVER]: restore a follower graph")
+		snapshotter = NewSnapshotter(config, router, wal)
+		if err := snapshotter.Load(); err != nil {
+			logger.Error("[SOUNDSERVER]: failed to restore a follower graph: ", err)
+
+			shutdownQueue.Shutdown()
+			return
+		}
+		shutdownQueue.Add(snapshotter)
+
+		if wal != nil {
+			// changes of the graph applied after the snapshot
+			router.Replay(wal.Applied())
+		}
+	}
+
+	logger.Info("[SOUNDSERVER]: create a server for a client")
+	server, err := NewServer(config, router, statistics)
+	if err != nil {
+		logger.Error("[SOUNDSERVER]: failed to init a client server: ", err)
+
+		shutdownQueue.Shutdown()
+		return
+	}
+	shutdownQueue.Add(server)
+
+	// shut down after the queue to wait for clients to read drained messages
+	shutdownQueue.Add(router)
 
 	logger.Info("[SOUNDSERVER]: create a queue")
-	queue := NewQueue(config, router)
+	queue := NewQueue(config, router, statistics)
+	if wal != nil {
+		queue.Replay(wal)
+	}
 	shutdownQueue.Add(queue)
 
 	logger.Info("[SOUNDSERVER]: create an event source")
@@ -69,15 +121,48 @@ func main() {
 	}
 	shutdownQueue.Add(eventSource)
 
-	logger.Info("[SOUNDSERVER]: create a server for a client")
-	server, err := NewServer(config, router, statistics)
-	if err != nil {
-		logger.Error("[SOUNDSERVER]: failed to init a client server: ", err)
+	// event sources in other wire formats push to the same queue
+	eventSources := []*EventSource{eventSource}
+	for codec, addr := range map[string]string{
+		CODEC_JSON:   config.EventSourceJson(),
+		CODEC_BINARY: config.EventSourceBinary(),
+	} {
+		if addr == "" {
+			continue
+		}
+
+		logger.Info("[SOUNDSERVER]: create an event source of ", codec)
+		eventCodec, _ := NewEventCodec(codec)
+		source, err := NewCodecEventSource(addr, eventCodec, queue, statistics)
+		if err != nil {
+			logger.Error("[SOUNDSERVER]: failed to init an event source server of ", codec, ": ", err)
+
+			shutdownQueue.Shutdown()
+			return
+		}
+		shutdownQueue.Add(source)
+
+		eventSources = append(eventSources, source)
+	}
 
-		shutdownQueue.Shutdown()
-		return
+	reloader := NewReloader(config, func() (*Config, error) {
+		return ParseConfig(os.Args[1:])
+	})
+	reloader.Add(queue)
+	reloader.Add(router)
+
+	var admin *Admin
+	if config.Admin() != "" {
+		logger.Info("[SOUNDSERVER]: create an admin server")
+		admin, err = NewAdmin(config, reloader, queue, router, statistics)
+		if err != nil {
+			logger.Error("[SOUNDSERVER]: failed to init an admin server: ", err)
+
+			shutdownQueue.Shutdown()
+			return
+		}
+		shutdownQueue.Add(admin)
 	}
-	shutdownQueue.Add(server)
 
 	var wait sync.WaitGroup
 
@@ -85,14 +170,34 @@ func main() {
 	wait.Add(1)
 	go func() {
 		// start all services
+		if wal != nil {
+			wal.Run()
+		}
+		if snapshotter != nil {
+			snapshotter.Run()
+		}
+		router.Run()
 		queue.Run()
-		eventSource.Run()
+		for _, source := range eventSources {
+			source.Run()
+		}
 		server.Run()
-		// wait for Ctrl+C
+		if admin != nil {
+			admin.Run()
+		}
+		// wait for Ctrl+C or a termination, reload a config by SIGHUP
 		interrupt := make(chan os.Signal, 2)
-		signal.Notify(interrupt, os.Interrupt) // CTRL-C
-		<-interrupt
-		// shut down all services
+		signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+
+		sig := <-interrupt
+		for sig == syscall.SIGHUP {
+			reloader.Reload()
+
+			sig = <-interrupt
+		}
+		// shut down all services: stop accepting events, drain the queue and buffers of clients,
+		// then close connections of clients
+		logger.Info("[SOUNDSERVER]: got ", sig, ", shutting down")
 		shutdownQueue.Shutdown()
 		// dump final statistics
 		logger.Info("[SOUNDSERVER]:", statistics.DumpState())
@@ -7,52 +7,6 @@ import (
 	"time"
 )
 
-type MessageType int
-
-const (
-	MESSAGE_BROADCAST     MessageType = iota + 1
-	MESSAGE_FOLLOW
-	MESSAGE_STATUS_UPDATE
-	MESSAGE_UNFOLLOW
-	MESSAGE_PRIVATE_MSG
-
-	MESSAGE_UNKNOWN
-)
-
-func (o MessageType) String() string {
-	switch o {
-	case MESSAGE_BROADCAST:
-		return "Broadcast"
-	case MESSAGE_FOLLOW:
-		return "Follow"
-	case MESSAGE_STATUS_UPDATE:
-		return "StatusUpdate"
-	case MESSAGE_UNFOLLOW:
-		return "Unfollow"
-	case MESSAGE_PRIVATE_MSG:
-		return "Private"
-	default:
-		return "Unknown"
-	}
-}
-
-func FromString(typ string) MessageType {
-	switch typ {
-	case "B":
-		return MESSAGE_BROADCAST
-	case "F":
-		return MESSAGE_FOLLOW
-	case "S":
-		return MESSAGE_STATUS_UPDATE
-	case "U":
-		return MESSAGE_UNFOLLOW
-	case "P":
-		return MESSAGE_PRIVATE_MSG
-	default:
-		return MESSAGE_UNKNOWN
-	}
-}
-
 var (
 	invalidMessageErr = errors.New("invalid message format")
 )
@@ -65,16 +19,28 @@ type Message struct {
 	to         int64
 	err        error
 	created    time.Time
+	// stage timestamps, the message is written to each client after it is routed
+	released time.Time
+	routed   time.Time
+	// an event source sent the message, it is used to detect duplicates
+	source string
 }
 
 func NewMessage(payload string) *Message {
 	parts := strings.Split(payload, "|")
 
-	return (&Message{
+	msg := &Message{
 		payload: payload,
-		typ:     FromString(parts[1]),
+		typ:     MESSAGE_UNKNOWN,
 		created: time.Now(),
-	}).Parse(parts)
+	}
+
+	// a payload without a type is invalid, but its sequenceId is still used to reply to an event source
+	if len(parts) > 1 {
+		msg.typ = FromString(parts[1])
+	}
+
+	return msg.Parse(parts)
 }
 
 func (o *Message) String() string {
@@ -86,34 +52,40 @@ func (o *Message) Parse(parts []string) *Message {
 
 	o.sequenceId, _ = strconv.ParseInt(parts[0], 10, 64)
 
-	switch o.typ {
-	case MESSAGE_FOLLOW, MESSAGE_PRIVATE_MSG, MESSAGE_UNFOLLOW:
-		if len(parts) == 4 {
-			o.from, err = strconv.ParseInt(parts[2], 10, 64)
-			if err != nil {
-				o.err = err
-			}
-
-			o.to, err = strconv.ParseInt(parts[3], 10, 64)
-			if err != nil {
-				o.err = err
-			}
-		} else {
-			o.err = invalidMessageErr
+	info := o.typ.Info()
+	if info == nil || len(parts) != 2+info.Layout.Fields() {
+		o.err = invalidMessageErr
+		return o
+	}
+
+	if info.Layout.Fields() > 0 {
+		if o.from, err = strconv.ParseInt(parts[2], 10, 64); err != nil {
+			o.err = err
 		}
-	case MESSAGE_STATUS_UPDATE:
-		if len(parts) == 3 {
-			o.from, o.err = strconv.ParseInt(parts[2], 10, 64)
-		} else {
-			o.err = invalidMessageErr
+	}
+
+	if info.Layout.Fields() > 1 {
+		if o.to, err = strconv.ParseInt(parts[3], 10, 64); err != nil {
+			o.err = err
 		}
-	case MESSAGE_UNKNOWN:
-		o.err = invalidMessageErr
 	}
 
 	return o
 }
 
+// FormatPayload writes a message in the pipe-delimited format sent to clients
+func FormatPayload(sequenceId int64, typ MessageType, from, to int64) string {
+	parts := []string{strconv.FormatInt(sequenceId, 10), typ.Code()}
+
+	if info := typ.Info(); info != nil {
+		for _, value := range []int64{from, to}[:info.Layout.Fields()] {
+			parts = append(parts, strconv.FormatInt(value, 10))
+		}
+	}
+
+	return strings.Join(parts, "|")
+}
+
 func (o *Message) FromTo() (int64, int64) {
 	return o.from, o.to
 }
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+)
+
+type MessageType int
+
+const (
+	MESSAGE_UNKNOWN MessageType = iota
+
+	MESSAGE_BROADCAST
+	MESSAGE_FOLLOW
+	MESSAGE_STATUS_UPDATE
+	MESSAGE_UNFOLLOW
+	MESSAGE_PRIVATE_MSG
+)
+
+// MessageLayout lists fields of a message after a type code in the pipe-delimited format
+type MessageLayout int
+
+const (
+	// <sequenceId>|<code>
+	LAYOUT_EMPTY MessageLayout = iota
+	// <sequenceId>|<code>|<from>
+	LAYOUT_FROM
+	// <sequenceId>|<code>|<from>|<to>
+	LAYOUT_FROM_TO
+)
+
+// Fields returns a number of fields after a type code
+func (o MessageLayout) Fields() int {
+	return int(o)
+}
+
+// MessageHandler routes a message of a type, the router is locked while a message is handled
+type MessageHandler func(*Router, *Message)
+
+// MessageTypeInfo declares a message type: its wire code, a name in statistics and logs, fields and routing.
+// A message of a type changing the follower graph, groups or blocklists has to be marked by State,
+// the write-ahead log keeps it until a snapshot of the graph includes it.
+type MessageTypeInfo struct {
+	Code    string
+	Name    string
+	Layout  MessageLayout
+	Handler MessageHandler
+	State   bool
+}
+
+var (
+	// infos of message types by a message type, MESSAGE_UNKNOWN has no info
+	messageTypes       = []*MessageTypeInfo{nil}
+	messageTypesByCode = map[string]MessageType{}
+)
+
+func init() {
+	RegisterMessageType(MESSAGE_BROADCAST, &MessageTypeInfo{
+		Code: "B", Name: "Broadcast", Layout: LAYOUT_EMPTY, Handler: (*Router).handleBroadcast,
+	})
+	RegisterMessageType(MESSAGE_FOLLOW, &MessageTypeInfo{
+		Code: "F", Name: "Follow", Layout: LAYOUT_FROM_TO, Handler: (*Router).handleFollow, State: true,
+	})
+	RegisterMessageType(MESSAGE_STATUS_UPDATE, &MessageTypeInfo{
+		Code: "S", Name: "StatusUpdate", Layout: LAYOUT_FROM, Handler: (*Router).handleStatusUpdate,
+	})
+	RegisterMessageType(MESSAGE_UNFOLLOW, &MessageTypeInfo{
+		Code: "U", Name: "Unfollow", Layout: LAYOUT_FROM_TO, Handler: (*Router).handleUnfollow, State: true,
+	})
+	RegisterMessageType(MESSAGE_PRIVATE_MSG, &MessageTypeInfo{
+		Code: "P", Name: "Private", Layout: LAYOUT_FROM_TO, Handler: (*Router).handlePrivateMsg,
+	})
+}
+
+// RegisterMessageType adds a message type. Types are registered by init functions before statistics are created,
+// a repeated type or code is a programming error.
+func RegisterMessageType(typ MessageType, info *MessageTypeInfo) {
+	if typ <= MESSAGE_UNKNOWN {
+		panic(fmt.Sprint("message type ", info.Name, " has an invalid id ", int(typ)))
+	}
+
+	if _, ok := messageTypesByCode[info.Code]; ok || info.Code == "" {
+		panic(fmt.Sprint("message type ", info.Name, " has a repeated or empty code '", info.Code, "'"))
+	}
+
+	for int(typ) >= len(messageTypes) {
+		messageTypes = append(messageTypes, nil)
+	}
+
+	if messageTypes[typ] != nil {
+		panic(fmt.Sprint("message type ", info.Name, " repeats an id of ", messageTypes[typ].Name))
+	}
+
+	messageTypes[typ] = info
+	messageTypesByCode[info.Code] = typ
+}
+
+// MessageTypes returns registered message types ordered by id
+func MessageTypes() []MessageType {
+	result := make([]MessageType, 0, len(messageTypes))
+
+	for typ, info := range messageTypes {
+		if info != nil {
+			result = append(result, MessageType(typ))
+		}
+	}
+
+	return result
+}
+
+// messageTypeLimit is an upper bound of ids of registered types, it is used to size arrays of counters
+func messageTypeLimit() int {
+	return len(messageTypes)
+}
+
+// Info returns a declaration of a registered type or nil
+func (o MessageType) Info() *MessageTypeInfo {
+	if o <= MESSAGE_UNKNOWN || int(o) >= len(messageTypes) {
+		return nil
+	}
+
+	return messageTypes[o]
+}
+
+func (o MessageType) String() string {
+	if info := o.Info(); info != nil {
+		return info.Name
+	}
+
+	return "Unknown"
+}
+
+// IsState reports a type changing the follower graph, groups or blocklists
+func (o MessageType) IsState() bool {
+	info := o.Info()
+
+	return info != nil && info.State
+}
+
+// Code returns a code of a type in the wire format
+func (o MessageType) Code() string {
+	if info := o.Info(); info != nil {
+		return info.Code
+	}
+
+	return ""
+}
+
+func FromString(typ string) MessageType {
+	if result, ok := messageTypesByCode[typ]; ok {
+		return result
+	}
+
+	return MESSAGE_UNKNOWN
+}
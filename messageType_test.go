@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+	"reflect"
+)
+
+const (
+	MESSAGE_TEST MessageType = 100
+)
+
+var (
+	testHandled []*Message
+)
+
+func init() {
+	RegisterMessageType(MESSAGE_TEST, &MessageTypeInfo{
+		Code: "TS", Name: "Test", Layout: LAYOUT_FROM,
+		Handler: func(router *Router, msg *Message) {
+			testHandled = append(testHandled, msg)
+		},
+	})
+}
+
+func TestMessageType_Registry(t *testing.T) {
+	testSuites := []*struct {
+		typ          MessageType
+		expectedCode string
+		expectedName string
+	}{
+		{typ: MESSAGE_BROADCAST, expectedCode: "B", expectedName: "Broadcast"},
+		{typ: MESSAGE_FOLLOW, expectedCode: "F", expectedName: "Follow"},
+		{typ: MESSAGE_STATUS_UPDATE, expectedCode: "S", expectedName: "StatusUpdate"},
+		{typ: MESSAGE_UNFOLLOW, expectedCode: "U", expectedName: "Unfollow"},
+		{typ: MESSAGE_PRIVATE_MSG, expectedCode: "P", expectedName: "Private"},
+		{typ: MESSAGE_TEST, expectedCode: "TS", expectedName: "Test"},
+		{typ: MESSAGE_UNKNOWN, expectedCode: "", expectedName: "Unknown"},
+		{typ: MESSAGE_TEST + 1, expectedCode: "", expectedName: "Unknown"},
+	}
+
+	for _, test := range testSuites {
+		if exist := test.typ.Code(); exist != test.expectedCode {
+			t.Error("failed to get a code of ", int(test.typ), ". Got '", exist, "', but expected is '", test.expectedCode, "'")
+		}
+
+		if exist := test.typ.String(); exist != test.expectedName {
+			t.Error("failed to get a name of ", int(test.typ), ". Got ", exist, ", but expected is ", test.expectedName)
+		}
+
+		if test.expectedCode != "" {
+			if exist := FromString(test.expectedCode); exist != test.typ {
+				t.Error("failed to find a type by a code ", test.expectedCode, ". Got ", exist, ", but expected is ", test.typ)
+			}
+		}
+	}
+
+	types := MessageTypes()
+	if types[0] != MESSAGE_BROADCAST || types[len(types)-1] != MESSAGE_TEST {
+		t.Error("failed to list registered types in order. Got ", types)
+	}
+}
+
+func TestMessageType_Custom(t *testing.T) {
+	msg := NewMessage("7|TS|5")
+	if err := msg.HasError(); err != nil || msg.typ != MESSAGE_TEST || msg.from != 5 {
+		t.Error("failed to parse a message of a custom type. Got ", msg.typ, " from ", msg.from, " with error ", err)
+	}
+
+	if err := NewMessage("7|TS|5|6").HasError(); err == nil {
+		t.Error("failed to catch an extra field of a custom type")
+	}
+
+	if exist, expected := FormatPayload(7, MESSAGE_TEST, 5, 6), "7|TS|5"; exist != expected {
+		t.Error("failed to format a custom type. Got ", exist, ", but expected is ", expected)
+	}
+
+	decoded, err := (&BinaryCodec{}).Decode(EncodeBinaryFrame(7, MESSAGE_TEST, 5, 0)[4:])
+	if err != nil || decoded.payload != "7|TS|5" {
+		t.Error("failed to decode a binary frame of a custom type. Got ", decoded.payload, " with error ", err)
+	}
+
+	testHandled = nil
+
+	router := NewRouter(&Config{}, NewStatistics())
+	router.PushMessage(msg)
+
+	if expected := []*Message{msg}; !reflect.DeepEqual(testHandled, expected) {
+		t.Error("failed to route a custom type by its handler. Got ", testHandled, ", but expected is ", expected)
+	}
+}
@@ -52,6 +52,8 @@ func TestNewMessage(t *testing.T) {
 		{payload: "634|S", expectedErr: true},
 		{payload: "634|S|34|56", expectedErr: true},
 		{payload: "634|J|34|56", expectedErr: true},
+		{payload: "634", expectedErr: true},
+		{payload: "", expectedErr: true},
 	}
 
 	for _, test := range testSuites {
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"io"
+	"fmt"
+	"sort"
+	"runtime"
+	"sync/atomic"
+)
+
+const (
+	METRICS_NAMESPACE = "queserver"
+)
+
+type MetricsQueue interface {
+	State() (int, int64)
+}
+
+type MetricsRouter interface {
+	RegisteredUsers() []int64
+}
+
+// WriteMetrics writes statistics and gauges of a running server in the Prometheus text format
+func WriteMetrics(w io.Writer, statistics *Statistics, queue MetricsQueue, router MetricsRouter) {
+	writeHeader := func(name, typ, help string) {
+		fmt.Fprintf(w, "# HELP %s_%s %s\n", METRICS_NAMESPACE, name, help)
+		fmt.Fprintf(w, "# TYPE %s_%s %s\n", METRICS_NAMESPACE, name, typ)
+	}
+
+	writeHeader("messages_total", "counter", "Messages by a type and a direction.")
+	for _, messageType := range MessageTypes() {
+		fmt.Fprintf(w, "%s_messages_total{type=\"%s\",direction=\"received\"} %d\n",
+			METRICS_NAMESPACE, messageType, atomic.LoadUint64(&statistics.received[messageType]))
+		fmt.Fprintf(w, "%s_messages_total{type=\"%s\",direction=\"sent\"} %d\n",
+			METRICS_NAMESPACE, messageType, atomic.LoadUint64(&statistics.sent[messageType]))
+	}
+
+	writeHeader("blocked_total", "counter", "Messages refused by recipients blocking a sender.")
+	for _, messageType := range MessageTypes() {
+		fmt.Fprintf(w, "%s_blocked_total{type=\"%s\"} %d\n",
+			METRICS_NAMESPACE, messageType, atomic.LoadUint64(&statistics.blocked[messageType]))
+	}
+
+	writeHeader("overflow_total", "counter", "Messages handled by an overflow policy of a client buffer.")
+	for _, policy := range []OverflowPolicy{OVERFLOW_DROP_OLDEST, OVERFLOW_DROP_NEWEST, OVERFLOW_DISCONNECT} {
+		fmt.Fprintf(w, "%s_overflow_total{policy=\"%s\"} %d\n",
+			METRICS_NAMESPACE, policy, atomic.LoadUint64(&statistics.overflow[policy]))
+	}
+
+	counters := []*struct {
+		name  string
+		help  string
+		value *uint64
+	}{
+		{name: "gaps_total", help: "Gaps of sequenceId skipped in the strict mode.", value: &statistics.gaps},
+		{name: "gaps_skipped_ids_total", help: "SequenceId skipped in the strict mode.", value: &statistics.gapsSkipped},
+		{name: "late_total", help: "Messages dropped after skipping their gap.", value: &statistics.late},
+		{name: "duplicates_total", help: "Messages rejected as duplicates.", value: &statistics.duplicates},
+	}
+
+	for _, counter := range counters {
+		writeHeader(counter.name, "counter", counter.help)
+		fmt.Fprintf(w, "%s_%s %d\n", METRICS_NAMESPACE, counter.name, atomic.LoadUint64(counter.value))
+	}
+
+	groups := statistics.Groups()
+
+	groupCounters := []*struct {
+		name  string
+		help  string
+		value func(GroupCounters) uint64
+	}{
+		{name: "group_messages_total", help: "Messages of a group sent by members.",
+			value: func(c GroupCounters) uint64 { return c.Messages }},
+		{name: "group_delivered_total", help: "Copies of messages of a group sent to members.",
+			value: func(c GroupCounters) uint64 { return c.Delivered }},
+		{name: "group_rejected_total", help: "Messages of a group sent by non-members.",
+			value: func(c GroupCounters) uint64 { return c.Rejected }},
+	}
+
+	groupsId := make([]int64, 0, len(groups))
+	for groupId := range groups {
+		groupsId = append(groupsId, groupId)
+	}
+	sort.Slice(groupsId, func(i, j int) bool { return groupsId[i] < groupsId[j] })
+
+	for _, counter := range groupCounters {
+		writeHeader(counter.name, "counter", counter.help)
+		for _, groupId := range groupsId {
+			fmt.Fprintf(w, "%s_%s{group=\"%d\"} %d\n", METRICS_NAMESPACE, counter.name, groupId, counter.value(groups[groupId]))
+		}
+	}
+
+	depth, _ := queue.State()
+
+	gauges := []*struct {
+		name  string
+		help  string
+		value int
+	}{
+		{name: "queue_size", help: "Messages waiting in the queue.", value: depth},
+		{name: "registered_clients", help: "Users with a connected client.", value: len(router.RegisteredUsers())},
+		{name: "goroutines", help: "Running goroutines.", value: runtime.NumGoroutine()},
+	}
+
+	for _, gauge := range gauges {
+		writeHeader(gauge.name, "gauge", gauge.help)
+		fmt.Fprintf(w, "%s_%s %d\n", METRICS_NAMESPACE, gauge.name, gauge.value)
+	}
+
+	writeHeader("latency_seconds", "histogram", "Latency of stages of a message from an event source to a client.")
+	for stage := LATENCY_QUEUE; stage < LATENCY_UNKNOWN; stage++ {
+		for _, messageType := range MessageTypes() {
+			statistics.Latency(stage, messageType).WritePrometheus(w, METRICS_NAMESPACE+"_latency_seconds",
+				fmt.Sprintf("type=\"%s\",stage=\"%s\"", messageType, stage))
+		}
+	}
+
+	writeHeader("fanout_recipients", "histogram", "Recipients of a message sent to a list of users.")
+	for _, messageType := range MessageTypes() {
+		if size, _ := statistics.Fanout(messageType); size.Count() > 0 {
+			size.WritePrometheus(w, METRICS_NAMESPACE+"_fanout_recipients", fmt.Sprintf("type=\"%s\"", messageType))
+		}
+	}
+
+	writeHeader("fanout_seconds", "histogram", "Time of sending a message to a list of users.")
+	for _, messageType := range MessageTypes() {
+		if size, duration := statistics.Fanout(messageType); size.Count() > 0 {
+			duration.WritePrometheus(w, METRICS_NAMESPACE+"_fanout_seconds", fmt.Sprintf("type=\"%s\"", messageType))
+		}
+	}
+}
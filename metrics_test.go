@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	config := &Config{
+		queueLimit:   1000,
+		clientBuffer: 16,
+	}
+	statistics := NewStatistics()
+	router := NewRouter(config, statistics)
+	queue := NewQueue(config, router, statistics)
+
+	router.RegisterClient(50, false)
+	queue.Accept(NewMessage("3|B"))
+	statistics.AddDuplicate()
+	now := time.Now()
+	statistics.ObserveLatency(&Message{
+		typ:      MESSAGE_BROADCAST,
+		created:  now.Add(-2 * time.Millisecond),
+		released: now.Add(-time.Millisecond),
+		routed:   now.Add(-time.Millisecond),
+	}, now)
+
+	buf := bytes.NewBuffer(nil)
+	WriteMetrics(buf, statistics, queue, router)
+	exist := buf.String()
+
+	testSuites := []string{
+		"# TYPE queserver_messages_total counter\n",
+		"queserver_messages_total{type=\"Broadcast\",direction=\"received\"} 0\n",
+		"queserver_overflow_total{policy=\"DropOldest\"} 0\n",
+		"queserver_duplicates_total 1\n",
+		"queserver_queue_size 1\n",
+		"queserver_registered_clients 1\n",
+		"# TYPE queserver_goroutines gauge\n",
+		"queserver_latency_seconds_bucket{type=\"Broadcast\",stage=\"total\",le=\"0.005\"} 1\n",
+		"queserver_latency_seconds_count{type=\"Broadcast\",stage=\"queue\"} 1\n",
+		"queserver_latency_seconds_count{type=\"Follow\",stage=\"total\"} 0\n",
+	}
+
+	for _, expected := range testSuites {
+		if !strings.Contains(exist, expected) {
+			t.Error("failed to write metrics. Got '", exist, "', but expected contains '", expected, "'")
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"strings"
+)
+
+// OverflowPolicy defines what to do with a message when an outbound buffer of a client is full
+type OverflowPolicy int
+
+const (
+	OVERFLOW_DROP_OLDEST OverflowPolicy = iota + 1
+	OVERFLOW_DROP_NEWEST
+	OVERFLOW_DISCONNECT
+
+	OVERFLOW_UNKNOWN
+)
+
+func (o OverflowPolicy) String() string {
+	switch o {
+	case OVERFLOW_DROP_OLDEST:
+		return "DropOldest"
+	case OVERFLOW_DROP_NEWEST:
+		return "DropNewest"
+	case OVERFLOW_DISCONNECT:
+		return "Disconnect"
+	default:
+		return "Unknown"
+	}
+}
+
+func ParseOverflowPolicy(policy string, defaultPolicy OverflowPolicy) OverflowPolicy {
+	switch strings.ToLower(policy) {
+	case "dropoldest", "drop_oldest":
+		return OVERFLOW_DROP_OLDEST
+	case "dropnewest", "drop_newest":
+		return OVERFLOW_DROP_NEWEST
+	case "disconnect":
+		return OVERFLOW_DISCONNECT
+	default:
+		return defaultPolicy
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseOverflowPolicy(t *testing.T) {
+	testSuites := []*struct {
+		in       string
+		expected OverflowPolicy
+	}{
+		{in: "DropOldest", expected: OVERFLOW_DROP_OLDEST},
+		{in: "drop_newest", expected: OVERFLOW_DROP_NEWEST},
+		{in: "DISCONNECT", expected: OVERFLOW_DISCONNECT},
+		{in: "unknown", expected: OVERFLOW_DROP_OLDEST},
+		{expected: OVERFLOW_DROP_OLDEST},
+	}
+
+	for _, test := range testSuites {
+		if exist := ParseOverflowPolicy(test.in, OVERFLOW_DROP_OLDEST); exist != test.expected {
+			t.Error("failed to parse overflow policy '", test.in, "'. Got ", exist, ", but expected is ", test.expected)
+		}
+	}
+}
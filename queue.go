@@ -4,9 +4,48 @@ import (
 	"sync"
 	"container/heap"
 	"time"
+	"strings"
+	"errors"
 	"github.com/7phs/coding-challenge-queserver/logger"
 )
 
+var (
+	duplicateMessageErr = errors.New("duplicate message")
+	lateMessageErr      = errors.New("late message, its gap is already skipped")
+	storeMessageErr     = errors.New("failed to store a message to the write-ahead log")
+)
+
+type QueueMode int
+
+const (
+	// release messages by QUEUE_LIMIT threshold or after out of QUEUE_TTL
+	QUEUE_MODE_LIMIT QueueMode = iota + 1
+	// release only contiguous runs of sequenceId, skip gaps after QUEUE_GAP_TIMEOUT
+	QUEUE_MODE_STRICT
+)
+
+func (o QueueMode) String() string {
+	switch o {
+	case QUEUE_MODE_LIMIT:
+		return "Limit"
+	case QUEUE_MODE_STRICT:
+		return "Strict"
+	default:
+		return "Unknown"
+	}
+}
+
+func ParseQueueMode(mode string, defaultMode QueueMode) QueueMode {
+	switch strings.ToLower(mode) {
+	case "limit":
+		return QUEUE_MODE_LIMIT
+	case "strict":
+		return QUEUE_MODE_STRICT
+	default:
+		return defaultMode
+	}
+}
+
 // An IntHeap is a min-heap of ints.
 type MsgHeap []*Message
 
@@ -41,21 +80,37 @@ type Queue struct {
 	queueLimit int64
 	queueTTL   time.Duration
 
-	chain MessageQueue
+	mode       QueueMode
+	gapTimeout time.Duration
+	releaseCh  chan struct{}
+	// the next expected sequenceId and a time of noticing a gap before it in the strict mode
+	nextId   int64
+	gapSince time.Time
+
+	chain      MessageQueue
+	wal        *Wal
+	dedup      *Deduplicator
+	statistics *Statistics
 
 	shutdown chan struct{}
 	wait     sync.WaitGroup
 }
 
-func NewQueue(config *Config, queue MessageQueue) *Queue {
+func NewQueue(config *Config, queue MessageQueue, statistics *Statistics) *Queue {
 	q := &Queue{
-		chain:  queue,
-		pullCh: make(chan int64),
+		chain:      queue,
+		statistics: statistics,
+		dedup:      NewDeduplicator(config.DedupWindow(), config.DedupBySource()),
+		pullCh:     make(chan int64),
+		releaseCh:  make(chan struct{}, 1),
 
 		shutdown: make(chan struct{}),
 
 		queueLimit: config.QueueLimit(),
 		queueTTL:   config.QueueTTL(),
+		mode:       config.QueueMode(),
+		gapTimeout: config.QueueGapTimeout(),
+		nextId:     1,
 	}
 
 	heap.Init(&q.queue)
@@ -63,30 +118,147 @@ func NewQueue(config *Config, queue MessageQueue) *Queue {
 	return q
 }
 
+// Sync writes accepted messages to a disk before confirming them to an event source
+func (o *Queue) Sync() error {
+	if o.wal == nil {
+		return nil
+	}
+
+	if err := o.wal.Sync(); err != nil {
+		logger.Error("[QUEUE]: failed to sync the write-ahead log: ", err)
+
+		return storeMessageErr
+	}
+
+	return nil
+}
+
+// Replay attaches a write-ahead log and re-queues all messages undelivered before a restart
+func (o *Queue) Replay(wal *Wal) *Queue {
+	o.Lock()
+	defer o.Unlock()
+
+	o.wal = wal
+
+	pending := wal.Pending()
+	for _, msg := range pending {
+		heap.Push(&o.queue, msg)
+		o.dedup.Seen(msg.source, msg.sequenceId)
+	}
+
+	// the strict mode continues after the last released message, not from the first sequenceId
+	lastDelivered := wal.LastDelivered()
+	if lastDelivered > 0 {
+		o.nextId = lastDelivered + 1
+	}
+
+	if len(pending) > 0 && (lastDelivered == 0 || pending[0].sequenceId < o.nextId) {
+		o.nextId = pending[0].sequenceId
+	}
+
+	logger.Info("[QUEUE]: replay ", len(pending), " undelivered messages")
+
+	return o
+}
+
 func (o *Queue) PushMessage(msg *Message) {
+	o.Accept(msg)
+}
+
+// Accept pushes a message into the queue and reports why it is rejected
+func (o *Queue) Accept(msg *Message) error {
 	if !msg.IsValid() {
 		logger.Debug("[QUEUE]: push invalid message ", msg.payload)
 
-		return
+		return invalidMessageErr
 	}
 
 	logger.Debug("[QUEUE]: push message ", msg.payload)
 
+	if o.dedup.Seen(msg.source, msg.sequenceId) {
+		logger.Debug("[QUEUE]: drop a duplicate message ", msg.payload, " from ", msg.source)
+
+		o.statistics.AddDuplicate()
+		return duplicateMessageErr
+	}
+
+	// an early check skips writing a late message to the write-ahead log, it is checked again when pushed
+	if o.mode == QUEUE_MODE_STRICT && o.isLate(msg) {
+		o.dropLate(false, msg)
+		return lateMessageErr
+	}
+
+	if o.wal != nil {
+		if err := o.wal.Append(msg); err != nil {
+			logger.Error("[QUEUE]: failed to write a message to the write-ahead log: ", err)
+
+			// the source retries a message which is not accepted
+			o.dedup.Forget(msg.source, msg.sequenceId)
+			return storeMessageErr
+		}
+	}
+
 	// better use lock free queue. Now, it is trade-off
-	peakSequenceId := func () int64 {
+	peakSequenceId, queueLimit, late := func () (int64, int64, bool) {
 		o.Lock()
 		defer o.Unlock()
 
+		// a gap can be skipped after the first check
+		if o.mode == QUEUE_MODE_STRICT && msg.sequenceId < o.nextId {
+			return 0, 0, true
+		}
+
 		heap.Push(&o.queue, msg)
 
-		return o.queue.Peek().sequenceId
+		return o.queue.Peek().sequenceId, o.queueLimit, false
 	}()
 
-	if msg.sequenceId>o.queueLimit && msg.sequenceId - peakSequenceId > o.queueLimit {
-		limitId := msg.sequenceId-o.queueLimit
+	if late {
+		o.dropLate(true, msg)
+		return lateMessageErr
+	}
+
+	if o.mode == QUEUE_MODE_STRICT {
+		if msg.sequenceId == peakSequenceId {
+			// non-blocking, one pending signal is enough to release a run
+			select {
+			case o.releaseCh <- struct{}{}:
+			default:
+			}
+		}
+
+		return nil
+	}
+
+	if msg.sequenceId>queueLimit && msg.sequenceId - peakSequenceId > queueLimit {
+		limitId := msg.sequenceId-queueLimit
 
 		go func() { o.pullCh <- limitId }()
 	}
+
+	return nil
+}
+
+// State returns a count of waiting messages and sequenceId of the head of the queue, 0 for the empty queue
+func (o *Queue) State() (depth int, headId int64) {
+	o.Lock()
+	defer o.Unlock()
+
+	if o.queue.Len() > 0 {
+		headId = o.queue.Peek().sequenceId
+	}
+
+	return o.queue.Len(), headId
+}
+
+// Reload applies a limit, TTL and a gap timeout of a new config, a mode is changed only by a restart
+func (o *Queue) Reload(config *Config) {
+	o.Lock()
+	defer o.Unlock()
+
+	o.queueLimit = config.QueueLimit()
+	o.queueTTL = config.QueueTTL()
+	o.gapTimeout = config.QueueGapTimeout()
 }
 
 func (o *Queue) Run() {
@@ -102,9 +274,17 @@ func (o *Queue) Run() {
 			case limitId := <-o.pullCh:
 				o.pullByLimit(limitId)
 
-			// start to send all msg stored older than queueTTL
-			case <-time.After(o.queueTTL):
-				o.pullByTTL()
+			// start to send a contiguous run of messages in the strict mode
+			case <-o.releaseCh:
+				o.pullStrict()
+
+			// start to send all msg stored older than queueTTL or skip timed out gaps
+			case <-time.After(o.tick()):
+				if o.mode == QUEUE_MODE_STRICT {
+					o.pullStrict()
+				} else {
+					o.pullByTTL()
+				}
 
 			case <-o.shutdown:
 				logger.Info("[QUEUE]: shutdown working goroutin")
@@ -116,16 +296,20 @@ func (o *Queue) Run() {
 }
 
 func (o *Queue) pullByLimit(limitId int64) {
-	if o.queueLimit>10 {
-		limitId -= limitId%(o.queueLimit/10)
-	}
-
-	logger.Debug("[QUEUE]: pull messages by limit ", limitId)
-
 	pullQueues := func () []*Message {
 		o.Lock()
 		defer o.Unlock()
 
+		if o.queueLimit>10 {
+			limitId -= limitId%(o.queueLimit/10)
+		}
+
+		logger.Debug("[QUEUE]: pull messages by limit ", limitId)
+
+		if o.queue.Len() == 0 {
+			return nil
+		}
+
 		result := make([]*Message, 0, MaxInt64(16, limitId - o.queue.Peek().sequenceId))
 
 		for o.queue.Len()>0 && o.queue.Peek().sequenceId<limitId {
@@ -139,14 +323,14 @@ func (o *Queue) pullByLimit(limitId int64) {
 }
 
 func (o *Queue) pullByTTL() {
-	limit := time.Now().Add(-o.queueTTL)
-
-	logger.Debug("[QUEUE]: pull messages by TTL ", limit)
-
 	pullQueues := func () []*Message {
 		o.Lock()
 		defer o.Unlock()
 
+		limit := time.Now().Add(-o.queueTTL)
+
+		logger.Debug("[QUEUE]: pull messages by TTL ", limit)
+
 		result := make([]*Message, 0, 128)
 
 		for o.queue.Len() > 0 && o.queue.Peek().created.Before(limit) {
@@ -159,18 +343,146 @@ func (o *Queue) pullByTTL() {
 	o.pullMessages(pullQueues)
 }
 
+func (o *Queue) tick() time.Duration {
+	o.Lock()
+	defer o.Unlock()
+
+	if o.mode == QUEUE_MODE_STRICT {
+		return MaxDuration(o.gapTimeout/4, time.Millisecond)
+	}
+
+	return o.queueTTL
+}
+
+func (o *Queue) isLate(msg *Message) bool {
+	o.Lock()
+	defer o.Unlock()
+
+	return msg.sequenceId < o.nextId
+}
+
+type queueGap struct {
+	from int64
+	to   int64
+}
+
+func (o *Queue) pullStrict() {
+	now := time.Now()
+	late := []*Message{}
+
+	pullQueues, gaps := func() ([]*Message, []queueGap) {
+		o.Lock()
+		defer o.Unlock()
+
+		result := make([]*Message, 0, 16)
+		gaps := []queueGap{}
+
+		for o.queue.Len() > 0 {
+			head := o.queue.Peek()
+
+			if head.sequenceId < o.nextId {
+				// a message pushed below released sequenceId
+				late = append(late, heap.Pop(&o.queue).(*Message))
+				continue
+			}
+
+			if head.sequenceId == o.nextId {
+				result = append(result, heap.Pop(&o.queue).(*Message))
+				o.nextId++
+				o.gapSince = time.Time{}
+				continue
+			}
+
+			if o.gapSince.IsZero() {
+				o.gapSince = now
+			}
+
+			if now.Sub(o.gapSince) < o.gapTimeout {
+				break
+			}
+
+			gaps = append(gaps, queueGap{from: o.nextId, to: head.sequenceId - 1})
+			o.nextId = head.sequenceId
+			o.gapSince = time.Time{}
+		}
+
+		return result, gaps
+	}()
+
+	for _, gap := range gaps {
+		logger.Warning("[QUEUE]: gap of sequenceId ", gap.from, "-", gap.to, " is timed out, skip it")
+
+		o.statistics.AddGap(gap.to - gap.from + 1)
+	}
+
+	o.dropLate(true, late...)
+	o.pullMessages(pullQueues)
+}
+
+// dropLate counts messages arrived after their sequenceId is released or skipped,
+// stored ones are released in the write-ahead log to skip replaying them
+func (o *Queue) dropLate(stored bool, msgs ...*Message) {
+	if len(msgs) == 0 {
+		return
+	}
+
+	sequencesId := make([]int64, 0, len(msgs))
+	for _, msg := range msgs {
+		logger.Warning("[QUEUE]: drop a late message ", msg.payload, ", its sequenceId is already released or skipped")
+
+		o.statistics.AddLate()
+		sequencesId = append(sequencesId, msg.sequenceId)
+	}
+
+	if stored && o.wal != nil {
+		if err := o.wal.Released(sequencesId...); err != nil {
+			logger.Error("[QUEUE]: failed to release late messages in the write-ahead log: ", err)
+		}
+	}
+}
+
 func (o *Queue) pullMessages(msgs []*Message) {
 	for _, msg := range msgs {
 		logger.Debug("[QUEUE]: pull message ", msg)
 
+		msg.released = time.Now()
 		o.chain.PushMessage(msg)
 	}
+
+	if o.wal != nil && len(msgs) > 0 {
+		sequencesId := make([]int64, 0, len(msgs))
+		for _, msg := range msgs {
+			sequencesId = append(sequencesId, msg.sequenceId)
+		}
+
+		if err := o.wal.Delivered(sequencesId...); err != nil {
+			logger.Error("[QUEUE]: failed to mark messages as delivered in the write-ahead log: ", err)
+		}
+	}
 }
 
+// Shutdown stops the working goroutine and releases all waiting messages in order, gaps are not waited for
 func (o *Queue) Shutdown() {
 	logger.Info("[QUEUE]: shutdown")
 
 	close(o.shutdown)
 
 	o.wait.Wait()
+
+	pullQueues := func() []*Message {
+		o.Lock()
+		defer o.Unlock()
+
+		result := make([]*Message, 0, o.queue.Len())
+
+		for o.queue.Len() > 0 {
+			result = append(result, heap.Pop(&o.queue).(*Message))
+		}
+
+		return result
+	}()
+
+	logger.Info("[QUEUE]: drain ", len(pullQueues), " messages")
+
+	o.pullMessages(pullQueues)
 }
@@ -4,6 +4,7 @@ import (
 	"testing"
 	"container/heap"
 	"reflect"
+	"sync"
 	"time"
 )
 
@@ -39,21 +40,35 @@ func TestMsgHeap(t *testing.T) {
 	}
 }
 
+// TestQueue collects sequenceId of messages pushed by a goroutine of a running queue
 type TestQueue struct {
+	sync.Mutex
+
 	sequencesId []int64
 }
 
 func (o *TestQueue) PushMessage(msg *Message) {
+	o.Lock()
+	defer o.Unlock()
+
 	o.sequencesId = append(o.sequencesId, msg.sequenceId)
 }
 
+// SequencesId returns a copy of collected sequenceId
+func (o *TestQueue) SequencesId() []int64 {
+	o.Lock()
+	defer o.Unlock()
+
+	return append([]int64(nil), o.sequencesId...)
+}
+
 func TestNewQueue(t *testing.T) {
 	testQueue := TestQueue{}
 
 	queue := NewQueue(&Config{
 		queueTTL:   24 * 60 * 1000,
 		queueLimit: 0,
-	}, &testQueue)
+	}, &testQueue, NewStatistics())
 	defer queue.Shutdown()
 
 	queue.Run()
@@ -78,12 +93,12 @@ func TestNewQueue(t *testing.T) {
 	time.Sleep(50 * time.Millisecond)
 
 	// last msg is waiting for a signal, but without timer or the next message never pull
-	if exist := len(testQueue.sequencesId); exist != int(expectedCount-1) {
+	if exist := len(testQueue.SequencesId()); exist != int(expectedCount-1) {
 		t.Error("failed to get all pushed messages. Got ", exist, ", but expected is ", expectedCount-1)
 	}
 
-	if !reflect.DeepEqual(testQueue.sequencesId, expectedIds[:expectedCount-1]) {
-		t.Error("failed to get all pushed messages. Got ", testQueue.sequencesId, ", but expected is ", expectedIds[:expectedCount-1])
+	if !reflect.DeepEqual(testQueue.SequencesId(), expectedIds[:expectedCount-1]) {
+		t.Error("failed to get all pushed messages. Got ", testQueue.SequencesId(), ", but expected is ", expectedIds[:expectedCount-1])
 	}
 
 }
@@ -94,7 +109,7 @@ func TestNewQueue_PullByTTL(t *testing.T) {
 	queue := NewQueue(&Config{
 		queueTTL:   5,
 		queueLimit: 1000,
-	}, &testQueue)
+	}, &testQueue, NewStatistics())
 	defer queue.Shutdown()
 
 	queue.Run()
@@ -114,12 +129,190 @@ func TestNewQueue_PullByTTL(t *testing.T) {
 	time.Sleep(50 * time.Millisecond)
 
 	// last msg is waiting for a signal, but without timer or the next message never pull
-	if exist := len(testQueue.sequencesId); exist != int(expectedCount) {
+	if exist := len(testQueue.SequencesId()); exist != int(expectedCount) {
 		t.Error("failed to get all pushed messages. Got ", exist, ", but expected is ", expectedCount)
 	}
 
-	if !reflect.DeepEqual(testQueue.sequencesId, expectedIds) {
-		t.Error("failed to get all pushed messages. Got ", testQueue.sequencesId, ", but expected is ", expectedIds)
+	if !reflect.DeepEqual(testQueue.SequencesId(), expectedIds) {
+		t.Error("failed to get all pushed messages. Got ", testQueue.SequencesId(), ", but expected is ", expectedIds)
+	}
+
+}
+
+func TestQueue_ShutdownDrain(t *testing.T) {
+	testQueue := TestQueue{}
+
+	queue := NewQueue(&Config{
+		queueTTL:        24 * 60 * 1000,
+		queueLimit:      1000,
+		queueMode:       QUEUE_MODE_STRICT,
+		queueGapTimeout: 24 * 60 * 1000,
+	}, &testQueue, NewStatistics())
+
+	queue.Run()
+
+	for _, sequenceId := range []int64{5, 3, 4} {
+		queue.PushMessage(&Message{
+			sequenceId: sequenceId,
+			typ:        MESSAGE_BROADCAST,
+		})
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	// messages wait for a gap of 1-2
+	if exist := len(testQueue.SequencesId()); exist != 0 {
+		t.Error("failed to keep messages after a gap. Got ", exist, ", but expected is ", 0)
+	}
+
+	queue.Shutdown()
+
+	expected := []int64{3, 4, 5}
+	if !reflect.DeepEqual(testQueue.SequencesId(), expected) {
+		t.Error("failed to drain messages on shutdown. Got ", testQueue.SequencesId(), ", but expected is ", expected)
+	}
+
+	if depth, _ := queue.State(); depth != 0 {
+		t.Error("failed to empty the queue. Got ", depth, ", but expected is ", 0)
+	}
+}
+
+func TestParseQueueMode(t *testing.T) {
+	testSuites := []*struct {
+		in       string
+		expected QueueMode
+	}{
+		{in: "Limit", expected: QUEUE_MODE_LIMIT},
+		{in: "STRICT", expected: QUEUE_MODE_STRICT},
+		{in: "unknown", expected: QUEUE_MODE_LIMIT},
+		{expected: QUEUE_MODE_LIMIT},
+	}
+
+	for _, test := range testSuites {
+		if exist := ParseQueueMode(test.in, QUEUE_MODE_LIMIT); exist != test.expected {
+			t.Error("failed to parse queue mode '", test.in, "'. Got ", exist, ", but expected is ", test.expected)
+		}
+	}
+}
+
+func TestNewQueue_Strict(t *testing.T) {
+	testQueue := TestQueue{}
+	statistics := NewStatistics()
+
+	queue := NewQueue(&Config{
+		queueMode:       QUEUE_MODE_STRICT,
+		queueGapTimeout: 24 * 60 * 1000,
+	}, &testQueue, statistics)
+
+	for _, sequenceId := range []int64{3, 2, 5, 1} {
+		queue.PushMessage(&Message{
+			sequenceId: sequenceId,
+			typ:        MESSAGE_PRIVATE_MSG,
+		})
+	}
+
+	queue.pullStrict()
+
+	expected := []int64{1, 2, 3}
+	if !reflect.DeepEqual(testQueue.SequencesId(), expected) {
+		t.Error("failed to release a contiguous run. Got ", testQueue.SequencesId(), ", but expected is ", expected)
+	}
+
+	// the gap before 5 is not timed out yet
+	queue.pullStrict()
+
+	if !reflect.DeepEqual(testQueue.SequencesId(), expected) {
+		t.Error("failed to wait for a gap. Got ", testQueue.SequencesId(), ", but expected is ", expected)
+	}
+
+	queue.gapTimeout = 0
+	queue.pullStrict()
+
+	expected = []int64{1, 2, 3, 5}
+	if !reflect.DeepEqual(testQueue.SequencesId(), expected) {
+		t.Error("failed to skip a timed out gap. Got ", testQueue.SequencesId(), ", but expected is ", expected)
+	}
+
+	if statistics.gaps != 1 || statistics.gapsSkipped != 1 {
+		t.Error("failed to count a gap. Got ", statistics.gaps, "/", statistics.gapsSkipped, ", but expected is 1/1")
+	}
+
+	// the gap is already skipped
+	queue.PushMessage(&Message{
+		sequenceId: 4,
+		typ:        MESSAGE_PRIVATE_MSG,
+	})
+	queue.pullStrict()
+
+	if !reflect.DeepEqual(testQueue.SequencesId(), expected) {
+		t.Error("failed to drop a late message. Got ", testQueue.SequencesId(), ", but expected is ", expected)
+	}
+
+	if statistics.late != 1 {
+		t.Error("failed to count a late message. Got ", statistics.late, ", but expected is ", 1)
+	}
+}
+
+func TestNewQueue_StrictRun(t *testing.T) {
+	testQueue := TestQueue{}
+
+	queue := NewQueue(&Config{
+		queueMode:       QUEUE_MODE_STRICT,
+		queueGapTimeout: 20,
+	}, &testQueue, NewStatistics())
+	defer queue.Shutdown()
+
+	queue.Run()
+
+	for _, sequenceId := range []int64{2, 1, 4} {
+		queue.PushMessage(&Message{
+			sequenceId: sequenceId,
+			typ:        MESSAGE_PRIVATE_MSG,
+		})
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	expected := []int64{1, 2, 4}
+	if !reflect.DeepEqual(testQueue.SequencesId(), expected) {
+		t.Error("failed to release messages in the strict mode. Got ", testQueue.SequencesId(), ", but expected is ", expected)
 	}
+}
+
+func TestQueue_AcceptDuplicate(t *testing.T) {
+	testQueue := TestQueue{}
+	statistics := NewStatistics()
 
+	queue := NewQueue(&Config{
+		queueTTL:    0,
+		queueLimit:  1000,
+		dedupWindow: 100,
+	}, &testQueue, statistics)
+
+	testSuites := []*struct {
+		msg      *Message
+		expected error
+	}{
+		{msg: &Message{sequenceId: 1, typ: MESSAGE_BROADCAST}},
+		{msg: &Message{sequenceId: 2, typ: MESSAGE_BROADCAST}},
+		{msg: &Message{sequenceId: 1, typ: MESSAGE_BROADCAST}, expected: duplicateMessageErr},
+		{msg: &Message{sequenceId: 0, typ: MESSAGE_BROADCAST}, expected: invalidMessageErr},
+	}
+
+	for i, test := range testSuites {
+		if exist := queue.Accept(test.msg); exist != test.expected {
+			t.Error(i, ": failed to accept a message. Got ", exist, ", but expected is ", test.expected)
+		}
+	}
+
+	queue.pullByTTL()
+
+	expected := []int64{1, 2}
+	if !reflect.DeepEqual(testQueue.SequencesId(), expected) {
+		t.Error("failed to skip a duplicate. Got ", testQueue.SequencesId(), ", but expected is ", expected)
+	}
+
+	if statistics.duplicates != 1 {
+		t.Error("failed to count a duplicate. Got ", statistics.duplicates, ", but expected is ", 1)
+	}
 }
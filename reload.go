@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"github.com/7phs/coding-challenge-queserver/logger"
+)
+
+type Reloadable interface {
+	Reload(*Config)
+}
+
+// ReloadReport lists changed parameters by names of environment variables
+type ReloadReport struct {
+	Applied []string `json:"applied"`
+	Restart []string `json:"restart"`
+}
+
+// Reloader re-reads a config and applies reloadable parameters to running components
+type Reloader struct {
+	sync.Mutex
+
+	config *Config
+	parse  func() (*Config, error)
+	items  []Reloadable
+}
+
+func NewReloader(config *Config, parse func() (*Config, error)) *Reloader {
+	return &Reloader{
+		config: config,
+		parse:  parse,
+	}
+}
+
+func (o *Reloader) Add(item Reloadable) {
+	o.items = append(o.items, item)
+}
+
+// Config returns the applied config
+func (o *Reloader) Config() *Config {
+	o.Lock()
+	defer o.Unlock()
+
+	return o.config
+}
+
+// reloadLogger applies only changed logging parameters, so overrides set by the admin endpoint
+// survive reloading of other parameters
+func (o *Reloader) reloadLogger(changed map[string]bool) {
+	if changed[CONFIG_LOG_LEVEL] {
+		logger.SetFlags(o.config.LogLevel())
+	}
+
+	if changed[CONFIG_LOG_FORMAT] {
+		logger.SetFormat(o.config.LogFormat())
+	}
+
+	if changed[CONFIG_LOG_COMPONENTS] || changed[CONFIG_LOG_USERS] {
+		components, users := logger.Overrides()
+
+		if changed[CONFIG_LOG_COMPONENTS] {
+			components = o.config.LogComponents()
+		}
+		if changed[CONFIG_LOG_USERS] {
+			users = o.config.LogUsers()
+		}
+
+		logger.SetOverrides(components, users)
+	}
+
+	if changed[CONFIG_LOG_SAMPLE_FIRST] || changed[CONFIG_LOG_SAMPLE_EVERY] {
+		logger.SetSampling(o.config.LogSampleFirst(), o.config.LogSampleEvery())
+	}
+}
+
+func (o *Reloader) Reload() (*ReloadReport, error) {
+	logger.Info("[RELOADER]: reload a config")
+
+	config, err := o.parse()
+	if err != nil {
+		logger.Error("[RELOADER]: failed to read a config, keep the current one: ", err)
+
+		return nil, err
+	}
+
+	o.Lock()
+	defer o.Unlock()
+
+	report := &ReloadReport{
+		Applied: []string{},
+		Restart: []string{},
+	}
+
+	changed := map[string]bool{}
+
+	current := o.config.Dump()
+	for name, value := range config.Dump() {
+		if current[name] == value {
+			continue
+		}
+
+		changed[name] = true
+
+		change := fmt.Sprint(name, "=", current[name], "->", value)
+
+		if CONFIG_RELOADABLE[name] {
+			report.Applied = append(report.Applied, change)
+		} else {
+			report.Restart = append(report.Restart, change)
+		}
+	}
+
+	sort.Strings(report.Applied)
+	sort.Strings(report.Restart)
+
+	if len(report.Applied) > 0 {
+		o.config = o.config.Reload(config)
+
+		o.reloadLogger(changed)
+
+		for _, item := range o.items {
+			item.Reload(o.config)
+		}
+
+		logger.Info("[RELOADER]: applied ", report.Applied)
+	}
+
+	if len(report.Restart) > 0 {
+		logger.Warning("[RELOADER]: changes require a restart ", report.Restart)
+	}
+
+	if len(report.Applied) == 0 && len(report.Restart) == 0 {
+		logger.Info("[RELOADER]: nothing is changed")
+	}
+
+	return report, nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"github.com/7phs/coding-challenge-queserver/logger"
+)
+
+type TestReloadable struct {
+	configs []*Config
+}
+
+func (o *TestReloadable) Reload(config *Config) {
+	o.configs = append(o.configs, config)
+}
+
+func TestReloader_Reload(t *testing.T) {
+	var (
+		next    *Config
+		nextErr error
+		item    = &TestReloadable{}
+	)
+
+	reloader := NewReloader(&Config{
+		eventSource: ":9090",
+		queueLimit:  1000,
+		queueTTL:    500,
+	}, func() (*Config, error) {
+		return next, nextErr
+	})
+	reloader.Add(item)
+
+	testSuites := []*struct {
+		next            *Config
+		expectedApplied []string
+		expectedRestart []string
+		expectedReload  int
+	}{
+		{
+			next:            &Config{eventSource: ":9090", queueLimit: 1000, queueTTL: 500},
+			expectedApplied: []string{},
+			expectedRestart: []string{},
+		},
+		{
+			next:            &Config{eventSource: ":9091", queueLimit: 100, queueTTL: 50},
+			expectedApplied: []string{"QUEUE_LIMIT=1000->100", "QUEUE_TTL=500->50"},
+			expectedRestart: []string{"EVENT_SOURCE=:9090->:9091"},
+			expectedReload:  1,
+		},
+		{
+			next:            &Config{eventSource: ":9091", queueLimit: 100, queueTTL: 50},
+			expectedApplied: []string{},
+			// a restart is still required
+			expectedRestart: []string{"EVENT_SOURCE=:9090->:9091"},
+			expectedReload:  1,
+		},
+	}
+
+	for i, test := range testSuites {
+		next = test.next
+
+		report, err := reloader.Reload()
+		if err != nil {
+			t.Error(i, ": failed to reload with error: ", err)
+			continue
+		}
+
+		if !reflect.DeepEqual(report.Applied, test.expectedApplied) {
+			t.Error(i, ": failed to apply. Got ", report.Applied, ", but expected is ", test.expectedApplied)
+		}
+		if !reflect.DeepEqual(report.Restart, test.expectedRestart) {
+			t.Error(i, ": failed to report a restart. Got ", report.Restart, ", but expected is ", test.expectedRestart)
+		}
+		if exist := len(item.configs); exist != test.expectedReload {
+			t.Error(i, ": failed to reload components. Got ", exist, ", but expected is ", test.expectedReload)
+		}
+	}
+
+	if exist := reloader.Config(); exist.EventSource() != ":9090" || exist.QueueLimit() != 100 {
+		t.Error("failed to keep parameters requiring a restart. Got ", exist.EventSource(), " and ", exist.QueueLimit())
+	}
+
+	nextErr = errors.New("broken config")
+	if _, err := reloader.Reload(); err == nil {
+		t.Error("failed to catch an error of reading a config")
+	}
+}
+
+func TestReloader_KeepLogOverrides(t *testing.T) {
+	next := &Config{queueLimit: 1000}
+
+	reloader := NewReloader(&Config{queueLimit: 1000}, func() (*Config, error) {
+		return next, nil
+	})
+
+	// set by the admin endpoint
+	logger.SetUserLevel(1001, logger.DEBUG)
+	defer logger.SetOverrides(nil, nil)
+
+	next = &Config{queueLimit: 100}
+	reloader.Reload()
+
+	if _, users := logger.Overrides(); users[1001] != logger.DEBUG {
+		t.Error("failed to keep an override of a user after reloading other parameters. Got ", users)
+	}
+
+	next = &Config{queueLimit: 100, logComponents: map[string]int{"ROUTER": logger.ERROR}}
+	reloader.Reload()
+
+	components, users := logger.Overrides()
+	if components["ROUTER"] != logger.ERROR || users[1001] != logger.DEBUG {
+		t.Error("failed to apply only changed overrides. Got ", components, " and ", users)
+	}
+}
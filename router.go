@@ -5,38 +5,101 @@ import (
 	"sync/atomic"
 	"strings"
 	"strconv"
+	"time"
+	"sort"
 	"github.com/7phs/coding-challenge-queserver/logger"
 )
 
+const (
+	ROUTER_DRAIN_INTERVAL = 10 * time.Millisecond
+)
+
 type MessageQueue interface {
 	PushMessage(*Message)
 }
 
 type EventRouter interface {
-	RegisterClient(int64) <-chan *Message
-	UnregisterClient(int64)
+	RegisterClient(int64, bool) <-chan *Message
+	UnregisterClient(int64, <-chan *Message)
+	AckClient(int64, int64)
+	ResumeClient(int64, int64)
 }
 
 type UserInfo struct {
+	// guards an outbound channel and a mailbox against switching a registration
+	sync.Mutex
+
 	userId        int64
 	ch            chan *Message
-	subscriptions sync.Map
+	// ids of followers of the user and ids of users followed by the user, the router changes them together
+	subscriptions  sync.Map
+	following      sync.Map
+	followersCount int64
+	followingCount int64
+	// ids of groups of the user
+	groups sync.Map
+	// ids of users blocked by the user
+	blocked sync.Map
 
 	registered int32
+	mailbox    *Mailbox
+	history    *Mailbox
+	// messages of the history requested by a reconnecting client
+	resumed []*Message
+
+	// messages delivered to a client in the acknowledgement mode, but not acknowledged yet
+	ack       bool
+	unacked   []*Message
+	lastAcked int64
 }
 
+// Follow adds a follower of the user
 func (o *UserInfo) Follow(userId int64) {
-	o.subscriptions.Store(userId, true)
+	if _, loaded := o.subscriptions.LoadOrStore(userId, true); !loaded {
+		atomic.AddInt64(&o.followersCount, 1)
+	}
 }
 
 func (o *UserInfo) Unfollow(userId int64) {
-	o.subscriptions.Delete(userId)
+	if _, loaded := o.subscriptions.LoadAndDelete(userId); loaded {
+		atomic.AddInt64(&o.followersCount, -1)
+	}
 }
 
+// Range visits followers of the user
 func (o *UserInfo) Range(f func(key, value interface{}) bool) {
 	o.subscriptions.Range(f)
 }
 
+// AddFollowing adds a user followed by the user
+func (o *UserInfo) AddFollowing(userId int64) {
+	if _, loaded := o.following.LoadOrStore(userId, true); !loaded {
+		atomic.AddInt64(&o.followingCount, 1)
+	}
+}
+
+func (o *UserInfo) RemoveFollowing(userId int64) {
+	if _, loaded := o.following.LoadAndDelete(userId); loaded {
+		atomic.AddInt64(&o.followingCount, -1)
+	}
+}
+
+func (o *UserInfo) IsFollowing(userId int64) bool {
+	_, ok := o.following.Load(userId)
+
+	return ok
+}
+
+// RangeFollowing visits users followed by the user
+func (o *UserInfo) RangeFollowing(f func(key, value interface{}) bool) {
+	o.following.Range(f)
+}
+
+// FollowCounts returns counts of followers and of followed users
+func (o *UserInfo) FollowCounts() (int, int) {
+	return int(atomic.LoadInt64(&o.followersCount)), int(atomic.LoadInt64(&o.followingCount))
+}
+
 func (o *UserInfo) SetRegister(registered bool) {
 	v := int32(0)
 	if registered {
@@ -62,37 +125,171 @@ func (o *UserInfo) dumpSubscriptions() string {
 	return strings.Join(result, ", ")
 }
 
+// RouterLimits are limits of users, they are replaced as a whole by reloading a config
+type RouterLimits struct {
+	mailboxSize    int
+	mailboxTTL     time.Duration
+	historySize    int
+	historyTTL     time.Duration
+	clientBuffer   int
+	clientOverflow OverflowPolicy
+	clientUnacked  int
+
+	shutdownTimeout time.Duration
+}
+
+func NewRouterLimits(config *Config) *RouterLimits {
+	return &RouterLimits{
+		mailboxSize:     config.MailboxSize(),
+		mailboxTTL:      config.MailboxTTL(),
+		historySize:     config.HistorySize(),
+		historyTTL:      config.HistoryTTL(),
+		clientBuffer:    config.ClientBuffer(),
+		clientOverflow:  config.ClientOverflow(),
+		clientUnacked:   config.ClientUnackedLimit(),
+		shutdownTimeout: config.ShutdownTimeout(),
+	}
+}
+
 type Router struct {
-	clients sync.Map
+	// serializes applying messages against taking a snapshot of the follower graph
+	sync.Mutex
+
+	// users are owned by shards, shards send messages in own goroutines when the router is sharded
+	shards  []*RouterShard
+	sharded bool
+	// splits large fan-outs of a message between workers
+	fanoutPool *FanoutPool
+
+	groups         sync.Map
+	lastSequenceId int64
+	// sequenceId of applied messages changing the follower graph since the last saved snapshot,
+	// they are tracked only with a snapshot and a write-ahead log releasing them
+	applied      []int64
+	trackApplied bool
+	// messages of the write-ahead log are applied without sending them
+	replaying bool
+
+	// *RouterLimits
+	limits atomic.Value
+
+	statistics *Statistics
+}
+
+func NewRouter(config *Config, statistics *Statistics) *Router {
+	router := &Router{
+		statistics:   statistics,
+		sharded:      config.RouterShards() > 0,
+		fanoutPool:   NewFanoutPool(config.RouterFanoutWorkers(), config.RouterFanoutThreshold()),
+		trackApplied: config.SnapshotPath() != "" && config.WalDir() != "",
+	}
+
+	// an unsharded router keeps users in one shard and sends messages on the goroutine of the queue
+	for i := 0; i < int(MaxInt64(int64(config.RouterShards()), 1)); i++ {
+		router.shards = append(router.shards, NewRouterShard(i))
+	}
+
+	router.limits.Store(NewRouterLimits(config))
+
+	return router
 }
 
-func NewRouter() *Router {
-	return &Router{}
+// Run starts goroutines of shards and fan-out workers, it has to be called before pushing messages
+// to a sharded router or a router with fan-out workers
+func (o *Router) Run() {
+	o.fanoutPool.Run()
+
+	if !o.sharded {
+		return
+	}
+
+	logger.Info("[ROUTER]: start ", len(o.shards), " shards")
+
+	for _, shard := range o.shards {
+		shard.Run(o)
+	}
+}
+
+func (o *Router) Limits() *RouterLimits {
+	return o.limits.Load().(*RouterLimits)
+}
+
+// Reload applies limits of a new config. Outbound buffers are resized by the next registration of a client,
+// mailboxes and histories are resized immediately.
+func (o *Router) Reload(config *Config) {
+	limits := NewRouterLimits(config)
+
+	o.limits.Store(limits)
+
+	o.rangeUsers(func(userInfo *UserInfo) bool {
+		userInfo.Lock()
+		userInfo.mailbox.Resize(limits.mailboxSize, limits.mailboxTTL)
+		userInfo.history.Resize(limits.historySize, limits.historyTTL)
+		userInfo.Unlock()
+
+		return true
+	})
 }
 
 func (o *Router) PushMessage(msg *Message) {
 	logger.Debug("[ROUTER]: push message ", msg.payload)
 
-	switch msg.typ {
-	case MESSAGE_BROADCAST:
-		o.handleBroadcast(msg)
-	case MESSAGE_FOLLOW:
-		o.handleFollow(msg)
-	case MESSAGE_STATUS_UPDATE:
-		o.handleStatusUpdate(msg)
-	case MESSAGE_UNFOLLOW:
-		o.handleUnfollow(msg)
-	case MESSAGE_PRIVATE_MSG:
-		o.handlePrivateMsg(msg)
-	default:
+	o.Lock()
+	defer o.Unlock()
+
+	if msg.sequenceId > o.lastSequenceId {
+		o.lastSequenceId = msg.sequenceId
+	}
+
+	// set before passing the message to any client
+	msg.routed = time.Now()
+
+	o.apply(msg)
+}
+
+// Replay applies messages changing the follower graph kept by the write-ahead log since the last snapshot,
+// they are not sent to users again
+func (o *Router) Replay(msgs []*Message) {
+	o.Lock()
+	defer o.Unlock()
+
+	o.replaying = true
+	defer func() { o.replaying = false }()
+
+	for _, msg := range msgs {
+		if msg.sequenceId > o.lastSequenceId {
+			o.lastSequenceId = msg.sequenceId
+		}
+
+		o.apply(msg)
+	}
+
+	logger.Info("[ROUTER]: replay ", len(msgs), " messages changing the follower graph")
+}
+
+// apply handles a message by its type, the router has to be locked
+func (o *Router) apply(msg *Message) {
+	info := msg.typ.Info()
+	if info == nil || info.Handler == nil {
 		logger.Warning("[ROUTER]: processed a message with unknown type: ", msg)
+		return
+	}
+
+	if info.State && o.trackApplied {
+		o.applied = append(o.applied, msg.sequenceId)
 	}
+
+	info.Handler(o, msg)
 }
 
 func (o *Router) handleFollow(msg *Message) {
 	userInfo := o.getOrAddUserInfo(msg.to)
 	if userInfo != nil {
-		userInfo.Follow(msg.from)
+		if o.refuseBlocked(userInfo, msg) {
+			return
+		}
+
+		o.follow(msg.from, userInfo)
 		o.sendMessage(userInfo, msg)
 	}
 }
@@ -100,7 +297,24 @@ func (o *Router) handleFollow(msg *Message) {
 func (o *Router) handleUnfollow(msg *Message) {
 	userInfo := o.getOrAddUserInfo(msg.to)
 	if userInfo != nil {
-		userInfo.Unfollow(msg.from)
+		o.unfollow(msg.from, userInfo)
+	}
+}
+
+// follow adds a follower to a user and the user to followed users of the follower
+func (o *Router) follow(followerId int64, userInfo *UserInfo) {
+	userInfo.Follow(followerId)
+
+	if follower := o.getOrAddUserInfo(followerId); follower != nil {
+		follower.AddFollowing(userInfo.userId)
+	}
+}
+
+func (o *Router) unfollow(followerId int64, userInfo *UserInfo) {
+	userInfo.Unfollow(followerId)
+
+	if follower := o.getOrAddUserInfo(followerId); follower != nil {
+		follower.RemoveFollowing(userInfo.userId)
 	}
 }
 
@@ -110,7 +324,7 @@ func (o *Router) handleBroadcast(msg *Message) {
 
 func (o *Router) handlePrivateMsg(msg *Message) {
 	userInfo := o.getOrAddUserInfo(msg.to)
-	if userInfo != nil {
+	if userInfo != nil && !o.refuseBlocked(userInfo, msg) {
 		o.sendMessage(userInfo, msg)
 	}
 }
@@ -118,68 +332,431 @@ func (o *Router) handlePrivateMsg(msg *Message) {
 func (o *Router) handleStatusUpdate(msg *Message) {
 	userInfo := o.getOrAddUserInfo(msg.from)
 	if userInfo != nil {
+		recipients := []*UserInfo{}
+
 		userInfo.Range(func(key, _ interface{}) bool {
-			// go func(userId int64) {
 			userInfo := o.getOrAddUserInfo(key.(int64))
-			if userInfo != nil {
-				o.sendMessage(userInfo, msg)
+			if userInfo != nil && !o.refuseBlocked(userInfo, msg) {
+				recipients = append(recipients, userInfo)
 			}
 
-			// }(key.(int64))
-
 			return true
 		})
+
+		o.fanout(recipients, msg)
 	}
 }
 
 func (o *Router) sendBroadcast(msg *Message) {
+	recipients := []*UserInfo{}
+
 	// visit each partition
-	o.clients.Range(func(_, userInfo interface{}) bool {
-		// send message to user
-		o.sendMessage(userInfo.(*UserInfo), msg)
+	o.rangeUsers(func(userInfo *UserInfo) bool {
+		recipients = append(recipients, userInfo)
 
 		return true
 	})
+
+	o.fanout(recipients, msg)
 }
 
+// sendMessage passes a message to a shard of a user or puts it immediately to an unsharded router
 func (o *Router) sendMessage(userInfo *UserInfo, msg *Message) {
+	if o.replaying {
+		return
+	}
+
+	if o.sharded {
+		o.sendTask(o.shard(userInfo.userId), &routerTask{users: []*UserInfo{userInfo}, msg: msg})
+		return
+	}
+
+	o.putMessage(userInfo, msg)
+}
+
+// sendTask puts a task to an inbox of a shard, a task pushed after the shutdown is sent by the caller
+func (o *Router) sendTask(shard *RouterShard, task *routerTask) {
+	if shard.push(task) {
+		return
+	}
+
+	for _, userInfo := range task.users {
+		o.putMessage(userInfo, task.msg)
+	}
+}
+
+// putMessage puts a message to a history and to an outbound buffer or a mailbox of a user
+func (o *Router) putMessage(userInfo *UserInfo, msg *Message) {
+	userInfo.Lock()
+	defer userInfo.Unlock()
+
+	userInfo.history.Put(msg)
+
 	if !userInfo.IsRegistered() {
+		logger.Debug("[ROUTER]: store message ", msg.payload, " -> ", logger.UserId(userInfo.userId), " offline")
+
+		userInfo.mailbox.Put(msg)
+		return
+	}
+
+	logger.Debug("[ROUTER]: send message ", msg.payload, " -> ", logger.UserId(userInfo.userId))
+
+	o.deliver(userInfo, msg)
+}
+
+// deliver puts a message to an outbound buffer of a registered user without blocking.
+// A user has to be locked.
+func (o *Router) deliver(userInfo *UserInfo, msg *Message) {
+	limits := o.Limits()
+
+	if userInfo.ack {
+		// a limit reloaded to zero keeps the last message for a user registered in the acknowledgement mode
+		limit := int(MaxInt64(int64(limits.clientUnacked), 1))
+		if len(userInfo.unacked) >= limit {
+			userInfo.unacked = userInfo.unacked[len(userInfo.unacked)-limit+1:]
+		}
+
+		userInfo.unacked = append(userInfo.unacked, msg)
+	}
+
+	select {
+	case userInfo.ch <- msg:
 		return
+	default:
 	}
 
-	logger.Debug("[ROUTER]: send message ", msg.payload, " -> ", userInfo.userId)
+	logger.Debug("[ROUTER]: outbound buffer of #", logger.UserId(userInfo.userId), " is full, apply ", limits.clientOverflow)
+
+	o.statistics.AddOverflow(limits.clientOverflow)
+
+	switch limits.clientOverflow {
+	case OVERFLOW_DROP_OLDEST:
+		select {
+		case <-userInfo.ch:
+		default:
+		}
 
-	userInfo.ch <- msg
+		select {
+		case userInfo.ch <- msg:
+		default:
+		}
+
+	case OVERFLOW_DISCONNECT:
+		logger.Warning("[ROUTER]: disconnect a slow client, user id #", logger.UserId(userInfo.userId))
+
+		// a client reads buffered messages and stops on the closed channel
+		close(userInfo.ch)
+		userInfo.ch = nil
+		userInfo.SetRegister(false)
+
+		userInfo.mailbox.Put(msg)
+	}
 }
 
-func (o *Router) RegisterClient(userId int64) <-chan *Message {
-	logger.Info("[ROUTER]: register client, user id #", userId)
+func (o *Router) RegisterClient(userId int64, ack bool) <-chan *Message {
+	logger.Info("[ROUTER]: register client, user id #", logger.UserId(userId), ", acknowledgements ", ack)
 
 	userInfo := o.getOrAddUserInfo(userId)
 	if userInfo == nil {
 		return nil
 	}
 
+	userInfo.Lock()
+	defer userInfo.Unlock()
+
+	if userInfo.ch != nil {
+		close(userInfo.ch)
+	}
+
+	limits := o.Limits()
+
+	userInfo.ch = make(chan *Message, limits.clientBuffer)
 	userInfo.SetRegister(true)
-	return userInfo.ch
+
+	// messages stored while a user was offline go before live messages
+	msgs := userInfo.mailbox.Take()
+
+	// resume from the last acknowledged or seen message
+	if ack && limits.clientUnacked > 0 {
+		msgs = mergeMessages(userInfo.unacked, userInfo.resumed, msgs)
+	} else {
+		msgs = mergeMessages(userInfo.resumed, msgs)
+	}
+
+	userInfo.resumed = nil
+
+	userInfo.ack = ack && limits.clientUnacked > 0
+	userInfo.unacked = nil
+
+	if len(msgs) > 0 {
+		logger.Debug("[ROUTER]: flush ", len(msgs), " offline messages -> ", logger.UserId(userId))
+	}
+
+	ch := userInfo.ch
+	for _, msg := range msgs {
+		if !userInfo.IsRegistered() {
+			userInfo.mailbox.Put(msg)
+			continue
+		}
+
+		o.deliver(userInfo, msg)
+	}
+
+	return ch
+}
+
+// AckClient releases messages processed by a client up to the sequenceId
+func (o *Router) AckClient(userId int64, sequenceId int64) {
+	logger.Debug("[ROUTER]: acknowledge ", logger.SequenceId(sequenceId), " <- ", logger.UserId(userId))
+
+	userInfo := o.getOrAddUserInfo(userId)
+	if userInfo == nil {
+		return
+	}
+
+	userInfo.Lock()
+	defer userInfo.Unlock()
+
+	if sequenceId > userInfo.lastAcked {
+		userInfo.lastAcked = sequenceId
+	}
+
+	i := 0
+	for i < len(userInfo.unacked) && userInfo.unacked[i].sequenceId <= userInfo.lastAcked {
+		i++
+	}
+
+	userInfo.unacked = userInfo.unacked[i:]
+}
+
+// ResumeClient prepares messages of a retained history after the last seen sequenceId
+// to send them on registering a reconnected client
+func (o *Router) ResumeClient(userId int64, lastSeenId int64) {
+	logger.Info("[ROUTER]: resume client, user id #", logger.UserId(userId), " after #", logger.SequenceId(lastSeenId))
+
+	userInfo := o.getOrAddUserInfo(userId)
+	if userInfo == nil {
+		return
+	}
+
+	userInfo.Lock()
+	defer userInfo.Unlock()
+
+	userInfo.resumed = userInfo.history.After(lastSeenId)
 }
 
-func (o *Router) UnregisterClient(userId int64) {
-	logger.Info("[ROUTER]: unregister client, user id #", userId)
+// UnregisterClient disconnects a client owning the channel, a client replaced by a newer connection
+// of the same user does nothing
+func (o *Router) UnregisterClient(userId int64, ch <-chan *Message) {
+	logger.Info("[ROUTER]: unregister client, user id #", logger.UserId(userId))
 
 	userInfo := o.getOrAddUserInfo(userId)
 	if userInfo == nil {
 		return
 	}
 
+	userInfo.Lock()
+	defer userInfo.Unlock()
+
+	if userInfo.ch != ch {
+		logger.Debug("[ROUTER]: skip unregistering a replaced client, user id #", logger.UserId(userId))
+		return
+	}
+
 	userInfo.SetRegister(false)
+
+	if userInfo.ch == nil {
+		return
+	}
+
+	// keep messages not sent to the client for the next connection
+	for work := true; work; {
+		select {
+		case msg := <-userInfo.ch:
+			userInfo.mailbox.Put(msg)
+		default:
+			work = false
+		}
+	}
+
+	close(userInfo.ch)
+	userInfo.ch = nil
+}
+
+// RegisteredUsers returns ids of users with a connected client ordered by id
+func (o *Router) RegisteredUsers() []int64 {
+	result := []int64{}
+
+	o.rangeUsers(func(userInfo *UserInfo) bool {
+		if userInfo.IsRegistered() {
+			result = append(result, userInfo.userId)
+		}
+
+		return true
+	})
+
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+
+	return result
+}
+
+// Followers returns ids of followers of a user ordered by id, an unknown user is reported by false
+func (o *Router) Followers(userId int64) ([]int64, bool) {
+	userInfo, ok := o.loadUserInfo(userId)
+	if !ok {
+		return nil, false
+	}
+
+	result := []int64{}
+
+	userInfo.Range(func(key, _ interface{}) bool {
+		result = append(result, key.(int64))
+
+		return true
+	})
+
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+
+	return result, true
+}
+
+// Following returns ids of users followed by a user ordered by id, an unknown user is reported by false
+func (o *Router) Following(userId int64) ([]int64, bool) {
+	userInfo, ok := o.loadUserInfo(userId)
+	if !ok {
+		return nil, false
+	}
+
+	result := []int64{}
+
+	userInfo.RangeFollowing(func(key, _ interface{}) bool {
+		result = append(result, key.(int64))
+
+		return true
+	})
+
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+
+	return result, true
+}
+
+// Mutuals returns ids of users following a user and followed by it ordered by id,
+// an unknown user is reported by false
+func (o *Router) Mutuals(userId int64) ([]int64, bool) {
+	userInfo, ok := o.loadUserInfo(userId)
+	if !ok {
+		return nil, false
+	}
+
+	result := []int64{}
+
+	userInfo.Range(func(key, _ interface{}) bool {
+		if userInfo.IsFollowing(key.(int64)) {
+			result = append(result, key.(int64))
+		}
+
+		return true
+	})
+
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+
+	return result, true
+}
+
+// FollowCounts returns counts of followers of a user and of users followed by it,
+// an unknown user is reported by false
+func (o *Router) FollowCounts(userId int64) (int, int, bool) {
+	userInfo, ok := o.loadUserInfo(userId)
+	if !ok {
+		return 0, 0, false
+	}
+
+	followers, following := userInfo.FollowCounts()
+
+	return followers, following, true
 }
 
 func (o *Router) getOrAddUserInfo(userId int64) *UserInfo {
-	userInfo, _ := o.clients.LoadOrStore(userId, &UserInfo{
-		userId: userId,
-		ch:     make(chan *Message),
+	if userInfo, ok := o.loadUserInfo(userId); ok {
+		return userInfo
+	}
+
+	limits := o.Limits()
+
+	userInfo, _ := o.shard(userId).clients.LoadOrStore(userId, &UserInfo{
+		userId:  userId,
+		mailbox: NewMailbox(limits.mailboxSize, limits.mailboxTTL),
+		history: NewMailbox(limits.historySize, limits.historyTTL),
 	})
 
 	return userInfo.(*UserInfo)
 }
+
+// pendingMessages counts messages in outbound buffers of registered clients
+func (o *Router) pendingMessages() int {
+	pending := 0
+
+	o.rangeUsers(func(userInfo *UserInfo) bool {
+		userInfo.Lock()
+		if userInfo.ch != nil {
+			pending += len(userInfo.ch)
+		}
+		userInfo.Unlock()
+
+		return true
+	})
+
+	return pending
+}
+
+// Shutdown sends messages left in inboxes of shards and waits for clients to read their outbound buffers
+// up to the shutdown timeout
+func (o *Router) Shutdown() {
+	o.fanoutPool.Shutdown()
+
+	if o.sharded {
+		logger.Info("[ROUTER]: shutdown, drain inboxes of shards")
+
+		for _, shard := range o.shards {
+			shard.Shutdown()
+		}
+	}
+
+	logger.Info("[ROUTER]: shutdown, drain outbound buffers of clients")
+
+	deadline := time.Now().Add(o.Limits().shutdownTimeout)
+
+	for {
+		pending := o.pendingMessages()
+		if pending == 0 {
+			logger.Info("[ROUTER]: outbound buffers are drained")
+			return
+		}
+
+		if time.Now().After(deadline) {
+			logger.Warning("[ROUTER]: ", pending, " messages are not written to clients before the shutdown timeout")
+			return
+		}
+
+		time.Sleep(ROUTER_DRAIN_INTERVAL)
+	}
+}
+
+// mergeMessages joins lists of messages ordered by sequenceId skipping the same messages
+func mergeMessages(msgs ...[]*Message) []*Message {
+	seen := make(map[*Message]bool)
+	result := []*Message{}
+
+	for _, list := range msgs {
+		for _, msg := range list {
+			if !seen[msg] {
+				seen[msg] = true
+				result = append(result, msg)
+			}
+		}
+	}
+
+	sort.SliceStable(result, func(i, j int) bool { return result[i].sequenceId < result[j].sequenceId })
+
+	return result
+}
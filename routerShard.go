@@ -0,0 +1,141 @@
+package main
+
+import (
+	"sync"
+	"github.com/7phs/coding-challenge-queserver/logger"
+)
+
+// routerTask sends a message to users of a shard
+type routerTask struct {
+	users []*UserInfo
+	msg   *Message
+}
+
+// RouterShard owns users by their ids. Sends of messages to its users go through a FIFO inbox handled
+// by a goroutine of the shard. The router puts messages to inboxes in the order of sequenceId,
+// so each user receives messages in order. The inbox is unbounded, so a slow shard never blocks
+// the router and other shards; a slow client is limited by its outbound buffer and overflow policy.
+type RouterShard struct {
+	index   int
+	clients sync.Map
+
+	// guards the inbox
+	sync.Mutex
+	inbox  []*routerTask
+	closed bool
+	signal chan struct{}
+	wait   sync.WaitGroup
+}
+
+func NewRouterShard(index int) *RouterShard {
+	return &RouterShard{
+		index:  index,
+		signal: make(chan struct{}, 1),
+	}
+}
+
+func (o *RouterShard) Run(router *Router) {
+	o.wait.Add(1)
+
+	go func() {
+		logger.Debug("[ROUTER]: start a goroutin of shard #", o.index)
+
+		for {
+			tasks, closed := o.pull()
+
+			for _, task := range tasks {
+				for _, userInfo := range task.users {
+					router.putMessage(userInfo, task.msg)
+				}
+			}
+
+			if len(tasks) == 0 {
+				if closed {
+					break
+				}
+
+				<-o.signal
+			}
+		}
+
+		logger.Debug("[ROUTER]: stop a goroutin of shard #", o.index)
+		o.wait.Done()
+	}()
+}
+
+// push adds a task to the inbox without blocking, it returns false after the shard is stopped
+func (o *RouterShard) push(task *routerTask) bool {
+	o.Lock()
+	if o.closed {
+		o.Unlock()
+		return false
+	}
+
+	o.inbox = append(o.inbox, task)
+	o.Unlock()
+
+	select {
+	case o.signal <- struct{}{}:
+	default:
+	}
+
+	return true
+}
+
+// pull takes all tasks of the inbox
+func (o *RouterShard) pull() ([]*routerTask, bool) {
+	o.Lock()
+	defer o.Unlock()
+
+	tasks := o.inbox
+	o.inbox = nil
+
+	return tasks, o.closed
+}
+
+// Shutdown sends messages left in the inbox and stops the goroutine
+func (o *RouterShard) Shutdown() {
+	o.Lock()
+	o.closed = true
+	o.Unlock()
+
+	select {
+	case o.signal <- struct{}{}:
+	default:
+	}
+
+	o.wait.Wait()
+}
+
+// shard returns an owner of a user
+func (o *Router) shard(userId int64) *RouterShard {
+	count := int64(len(o.shards))
+
+	return o.shards[(userId%count+count)%count]
+}
+
+func (o *Router) loadUserInfo(userId int64) (*UserInfo, bool) {
+	userInfo, ok := o.shard(userId).clients.Load(userId)
+	if !ok {
+		return nil, false
+	}
+
+	return userInfo.(*UserInfo), true
+}
+
+// rangeUsers visits users of all shards
+func (o *Router) rangeUsers(f func(*UserInfo) bool) {
+	for _, shard := range o.shards {
+		next := true
+
+		shard.clients.Range(func(_, userInfo interface{}) bool {
+			next = f(userInfo.(*UserInfo))
+
+			return next
+		})
+
+		if !next {
+			return
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"reflect"
+	"math/rand"
+	"time"
+)
+
+func TestRouter_Shard(t *testing.T) {
+	router := NewRouter(&Config{routerShards: 4}, NewStatistics())
+
+	for _, userId := range []int64{0, 1, 5, -1, -6, 1 << 40} {
+		shard := router.shard(userId)
+
+		if expected := router.shards[((userId%4)+4)%4]; shard != expected {
+			t.Error("failed to find a shard of #", userId, ". Got ", shard.index, ", but expected is ", expected.index)
+		}
+
+		if owner := router.getOrAddUserInfo(userId); owner != router.getOrAddUserInfo(userId) {
+			t.Error("failed to keep a user #", userId, " in one shard")
+		}
+	}
+
+	count := 0
+	router.rangeUsers(func(_ *UserInfo) bool {
+		count++
+
+		return true
+	})
+
+	if count != 6 {
+		t.Error("failed to visit users of all shards. Got ", count, ", but expected is ", 6)
+	}
+}
+
+// TestRouter_ShardedOrder compares messages received by users of a sharded router with an unsharded one,
+// the sharded one passes fan-outs to shards
+func TestRouter_ShardedOrder(t *testing.T) {
+	const users = 16
+
+	payloads := []string{}
+	for sequenceId := 1; sequenceId <= 2000; sequenceId++ {
+		from, to := rand.Intn(users)+1, rand.Intn(users)+1
+
+		switch rand.Intn(6) {
+		case 0:
+			payloads = append(payloads, fmt.Sprint(sequenceId, "|F|", from, "|", to))
+		case 1:
+			payloads = append(payloads, fmt.Sprint(sequenceId, "|U|", from, "|", to))
+		case 2:
+			payloads = append(payloads, fmt.Sprint(sequenceId, "|P|", from, "|", to))
+		case 3:
+			payloads = append(payloads, fmt.Sprint(sequenceId, "|B"))
+		default:
+			payloads = append(payloads, fmt.Sprint(sequenceId, "|S|", from))
+		}
+	}
+
+	route := func(shards int64, fanoutWorkers int64) map[int64][]string {
+		router := NewRouter(&Config{
+			routerShards:          shards,
+			routerFanoutWorkers:   fanoutWorkers,
+			routerFanoutThreshold: 2,
+			clientBuffer:          4096,
+		}, NewStatistics())
+		router.Run()
+
+		chs := map[int64]<-chan *Message{}
+		for userId := int64(1); userId <= users; userId++ {
+			chs[userId] = router.RegisterClient(userId, false)
+		}
+
+		for _, payload := range payloads {
+			router.PushMessage(NewMessage(payload))
+		}
+
+		router.Shutdown()
+
+		result := map[int64][]string{}
+		for userId, ch := range chs {
+			result[userId] = []string{}
+
+			for len(ch) > 0 {
+				result[userId] = append(result[userId], (<-ch).payload)
+			}
+		}
+
+		return result
+	}
+
+	expected := route(0, 0)
+	exist := route(4, 4)
+
+	for userId := int64(1); userId <= users; userId++ {
+		if !reflect.DeepEqual(exist[userId], expected[userId]) {
+			t.Error("failed to keep an order of messages of #", userId, ". Got ", len(exist[userId]),
+				" messages, but expected are ", len(expected[userId]))
+		}
+	}
+}
+
+// TestRouter_ShardInbox pushes more messages than outbound buffers and inboxes of shards are able to keep
+// before shards are started, the router is not blocked by them
+func TestRouter_ShardInbox(t *testing.T) {
+	const messages = 5000
+
+	router := NewRouter(&Config{routerShards: 2, clientBuffer: messages + 1}, NewStatistics())
+
+	ch := router.RegisterClient(1, false)
+
+	done := make(chan struct{})
+	go func() {
+		for sequenceId := 1; sequenceId <= messages; sequenceId++ {
+			router.PushMessage(NewMessage(fmt.Sprint(sequenceId, "|B")))
+		}
+
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("failed to push messages to a stopped shard without blocking")
+	}
+
+	router.Run()
+	router.Shutdown()
+
+	if exist := len(ch); exist != messages {
+		t.Error("failed to send messages of an inbox. Got ", exist, ", but expected is ", messages)
+	}
+
+	router.PushMessage(NewMessage(fmt.Sprint(messages+1, "|B")))
+
+	if exist := len(ch); exist != messages+1 {
+		t.Error("failed to send a message after the shutdown by the caller. Got ", exist, ", but expected is ", messages+1)
+	}
+}
+
+// BenchmarkRouter_Shards broadcasts messages to users of routers with a different count of shards,
+// sends of shards run in parallel on as many cores as GOMAXPROCS allows
+func BenchmarkRouter_Shards(b *testing.B) {
+	const users = 4096
+
+	for _, shards := range []int64{0, 1, 2, 4, 8} {
+		b.Run(fmt.Sprint("shards-", shards), func(b *testing.B) {
+			router := NewRouter(&Config{routerShards: shards, clientBuffer: 16}, NewStatistics())
+
+			for userId := int64(1); userId <= users; userId++ {
+				router.RegisterClient(userId, false)
+			}
+
+			router.Run()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				router.PushMessage(NewMessage(fmt.Sprint(i+1, "|B")))
+			}
+
+			router.Shutdown()
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"testing"
 	"time"
 	"sync/atomic"
+	"reflect"
 )
 
 const (
@@ -39,7 +40,7 @@ func TestUser_dumpSubscriptions(t *testing.T) {
 }
 
 func TestNewRouter(t *testing.T) {
-	router := NewRouter()
+	router := NewRouter(&Config{clientBuffer: 16}, NewStatistics())
 
 	usersId := []int64{
 		2*TEST_ID_PARTITION + 56,
@@ -49,13 +50,13 @@ func TestNewRouter(t *testing.T) {
 	}
 
 	for _, userId := range usersId {
-		router.RegisterClient(userId)
+		router.RegisterClient(userId, false)
 	}
 
 	expectedCount := 4
 	existCount := 0
 
-	router.clients.Range(func(_, _ interface{}) bool {
+	router.rangeUsers(func(_ *UserInfo) bool {
 		existCount++
 
 		return true
@@ -67,7 +68,7 @@ func TestNewRouter(t *testing.T) {
 }
 
 func TestNewRouter_RegisterUnregister(t *testing.T) {
-	router := NewRouter()
+	router := NewRouter(&Config{clientBuffer: 16}, NewStatistics())
 
 	usersId := []int64{
 		2*TEST_ID_PARTITION + 56,
@@ -84,8 +85,9 @@ func TestNewRouter_RegisterUnregister(t *testing.T) {
 		3*TEST_ID_PARTITION + 57,
 	}
 
+	chs := map[int64]<-chan *Message{}
 	for _, userId := range usersId {
-		router.RegisterClient(userId)
+		chs[userId] = router.RegisterClient(userId, false)
 	}
 
 	expectedCount := 9
@@ -114,8 +116,8 @@ func TestNewRouter_RegisterUnregister(t *testing.T) {
 	expectedCount = 9
 	existCount = 0
 
-	router.UnregisterClient(usersId[0])
-	router.UnregisterClient(unknownUsersId[0])
+	router.UnregisterClient(usersId[0], chs[usersId[0]])
+	router.UnregisterClient(unknownUsersId[0], nil)
 
 	calc()
 
@@ -124,8 +126,32 @@ func TestNewRouter_RegisterUnregister(t *testing.T) {
 	}
 }
 
+// TestRouter_UnregisterReplacedClient checks an old connection of a user failing after a reconnection
+// keeps the newer one registered
+func TestRouter_UnregisterReplacedClient(t *testing.T) {
+	router := NewRouter(&Config{clientBuffer: 16}, NewStatistics())
+
+	userId := int64(TEST_ID_PARTITION + 1)
+
+	oldCh := router.RegisterClient(userId, false)
+	newCh := router.RegisterClient(userId, false)
+
+	router.UnregisterClient(userId, oldCh)
+
+	router.PushMessage(&Message{sequenceId: 1, typ: MESSAGE_PRIVATE_MSG, from: 1, to: userId})
+
+	select {
+	case msg, ok := <-newCh:
+		if !ok || msg.sequenceId != 1 {
+			t.Error("failed to send a message to a newer client. Got ", msg, " ", ok, ", but expected is #", 1)
+		}
+	default:
+		t.Error("failed to keep a newer client registered after unregistering a replaced one")
+	}
+}
+
 func TestRouter_PushMessage(t *testing.T) {
-	router := NewRouter()
+	router := NewRouter(&Config{clientBuffer: 16}, NewStatistics())
 
 	shutdown := make(chan struct{})
 
@@ -144,7 +170,7 @@ func TestRouter_PushMessage(t *testing.T) {
 	}
 
 	for userId := range usersId {
-		usersId[userId].ch = router.RegisterClient(userId)
+		usersId[userId].ch = router.RegisterClient(userId, false)
 
 		go func(userId int64) {
 			for {
@@ -387,3 +413,268 @@ func TestRouter_PushMessage(t *testing.T) {
 		3*TEST_ID_PARTITION + 6: 2,
 	})
 }
+
+func TestRouter_Mailbox(t *testing.T) {
+	router := NewRouter(&Config{
+		mailboxSize:  10,
+		mailboxTTL:   60 * 1000,
+		clientBuffer: 16,
+	}, NewStatistics())
+
+	userId := int64(TEST_ID_PARTITION + 1)
+
+	router.PushMessage(&Message{payload: "3|P|2|101", sequenceId: 3, typ: MESSAGE_PRIVATE_MSG, from: 2, to: userId})
+	router.PushMessage(&Message{payload: "2|F|3|101", sequenceId: 2, typ: MESSAGE_FOLLOW, from: 3, to: userId})
+
+	ch := router.RegisterClient(userId, false)
+
+	go router.PushMessage(&Message{payload: "4|B", sequenceId: 4, typ: MESSAGE_BROADCAST})
+
+	exist := []int64{}
+	for len(exist) < 3 {
+		select {
+		case msg := <-ch:
+			exist = append(exist, msg.sequenceId)
+		case <-time.After(time.Second):
+			t.Fatal("failed to receive messages. Got ", exist)
+		}
+	}
+
+	expected := []int64{2, 3, 4}
+	if !reflect.DeepEqual(exist, expected) {
+		t.Error("failed to flush offline messages before live ones. Got ", exist, ", but expected is ", expected)
+	}
+}
+
+func TestRouter_Overflow(t *testing.T) {
+	testSuites := []*struct {
+		policy       OverflowPolicy
+		expected     []int64
+		expectedOpen bool
+	}{
+		{policy: OVERFLOW_DROP_OLDEST, expected: []int64{2, 3}, expectedOpen: true},
+		{policy: OVERFLOW_DROP_NEWEST, expected: []int64{1, 2}, expectedOpen: true},
+		{policy: OVERFLOW_DISCONNECT, expected: []int64{1, 2}, expectedOpen: false},
+	}
+
+	for _, test := range testSuites {
+		statistics := NewStatistics()
+		router := NewRouter(&Config{
+			mailboxSize:    10,
+			clientBuffer:   2,
+			clientOverflow: test.policy,
+		}, statistics)
+
+		userId := int64(TEST_ID_PARTITION + 1)
+		ch := router.RegisterClient(userId, false)
+
+		for sequenceId := int64(1); sequenceId <= 3; sequenceId++ {
+			router.PushMessage(&Message{sequenceId: sequenceId, typ: MESSAGE_PRIVATE_MSG, from: 1, to: userId})
+		}
+
+		exist := []int64{}
+		open := true
+		for work := true; work; {
+			select {
+			case msg, ok := <-ch:
+				if !ok {
+					open, work = false, false
+					break
+				}
+				exist = append(exist, msg.sequenceId)
+			default:
+				work = false
+			}
+		}
+
+		if !reflect.DeepEqual(exist, test.expected) {
+			t.Error(test.policy, ": failed to apply an overflow policy. Got ", exist, ", but expected is ", test.expected)
+		}
+
+		if open != test.expectedOpen {
+			t.Error(test.policy, ": failed to keep a state of a channel. Got ", open, ", but expected is ", test.expectedOpen)
+		}
+
+		if exist := atomic.LoadUint64(&statistics.overflow[test.policy]); exist != 1 {
+			t.Error(test.policy, ": failed to count an overflow. Got ", exist, ", but expected is ", 1)
+		}
+
+		if test.policy == OVERFLOW_DISCONNECT {
+			// the dropped message is waiting for the next connection
+			ch = router.RegisterClient(userId, false)
+
+			if msg := <-ch; msg.sequenceId != 3 {
+				t.Error(test.policy, ": failed to keep a message for the next connection. Got ", msg.sequenceId, ", but expected is ", 3)
+			}
+		}
+	}
+}
+
+func TestRouter_AckClient(t *testing.T) {
+	router := NewRouter(&Config{
+		mailboxSize:   10,
+		clientBuffer:  16,
+		clientUnacked: 16,
+	}, NewStatistics())
+
+	userId := int64(TEST_ID_PARTITION + 1)
+	ch := router.RegisterClient(userId, true)
+
+	for sequenceId := int64(1); sequenceId <= 4; sequenceId++ {
+		router.PushMessage(&Message{sequenceId: sequenceId, typ: MESSAGE_PRIVATE_MSG, from: 1, to: userId})
+	}
+
+	for i := 0; i < 4; i++ {
+		<-ch
+	}
+
+	// the client has processed only two messages before a disconnection
+	router.AckClient(userId, 2)
+	router.UnregisterClient(userId, ch)
+
+	router.PushMessage(&Message{sequenceId: 5, typ: MESSAGE_PRIVATE_MSG, from: 1, to: userId})
+
+	ch = router.RegisterClient(userId, true)
+
+	exist := []int64{}
+	for work := true; work; {
+		select {
+		case msg := <-ch:
+			exist = append(exist, msg.sequenceId)
+		default:
+			work = false
+		}
+	}
+
+	expected := []int64{3, 4, 5}
+	if !reflect.DeepEqual(exist, expected) {
+		t.Error("failed to resume from the last acknowledged message. Got ", exist, ", but expected is ", expected)
+	}
+}
+
+func TestRouter_ResumeClient(t *testing.T) {
+	router := NewRouter(&Config{
+		mailboxSize:  10,
+		historySize:  10,
+		clientBuffer: 16,
+	}, NewStatistics())
+
+	userId := int64(TEST_ID_PARTITION + 1)
+	ch := router.RegisterClient(userId, false)
+
+	for sequenceId := int64(1); sequenceId <= 3; sequenceId++ {
+		router.PushMessage(&Message{sequenceId: sequenceId, typ: MESSAGE_PRIVATE_MSG, from: 1, to: userId})
+	}
+
+	// the client has seen only the first message before a disconnection
+	<-ch
+	router.UnregisterClient(userId, ch)
+
+	router.PushMessage(&Message{sequenceId: 4, typ: MESSAGE_BROADCAST})
+
+	router.ResumeClient(userId, 1)
+	ch = router.RegisterClient(userId, false)
+
+	exist := []int64{}
+	for work := true; work; {
+		select {
+		case msg := <-ch:
+			exist = append(exist, msg.sequenceId)
+		default:
+			work = false
+		}
+	}
+
+	expected := []int64{2, 3, 4}
+	if !reflect.DeepEqual(exist, expected) {
+		t.Error("failed to resume after the last seen message. Got ", exist, ", but expected is ", expected)
+	}
+}
+
+func TestRouter_Shutdown(t *testing.T) {
+	router := NewRouter(&Config{
+		clientBuffer:    16,
+		shutdownTimeout: 50,
+	}, NewStatistics())
+
+	ch := router.RegisterClient(1, false)
+	router.PushMessage(&Message{payload: "1|B", sequenceId: 1, typ: MESSAGE_BROADCAST})
+	router.PushMessage(&Message{payload: "2|B", sequenceId: 2, typ: MESSAGE_BROADCAST})
+
+	// nobody reads the buffer, the router gives up after the timeout
+	start := time.Now()
+	router.Shutdown()
+	if exist := time.Since(start); exist < 50*time.Millisecond {
+		t.Error("failed to wait for the buffer up to the timeout. Got ", exist)
+	}
+
+	router.PushMessage(&Message{payload: "3|B", sequenceId: 3, typ: MESSAGE_BROADCAST})
+
+	go func() {
+		for i := 0; i < 3; i++ {
+			time.Sleep(5 * time.Millisecond)
+			<-ch
+		}
+	}()
+
+	router.Reload(&Config{
+		clientBuffer:    16,
+		shutdownTimeout: 1000,
+	})
+
+	router.Shutdown()
+	if exist := router.pendingMessages(); exist != 0 {
+		t.Error("failed to wait for a drained buffer. Got ", exist, ", but expected is ", 0)
+	}
+}
+
+func TestRouter_FollowIndex(t *testing.T) {
+	router := NewRouter(&Config{clientBuffer: 16, mailboxSize: 16}, NewStatistics())
+
+	for _, payload := range []string{
+		"1|F|1|2",
+		"2|F|1|3",
+		"3|F|2|1",
+		"4|F|3|1",
+		"5|F|1|2",
+		"6|U|1|3",
+		"7|U|1|4",
+	} {
+		router.PushMessage(NewMessage(payload))
+	}
+
+	testSuites := []*struct {
+		userId            int64
+		expectedFollowers []int64
+		expectedFollowing []int64
+		expectedMutuals   []int64
+	}{
+		{userId: 1, expectedFollowers: []int64{2, 3}, expectedFollowing: []int64{2}, expectedMutuals: []int64{2}},
+		{userId: 2, expectedFollowers: []int64{1}, expectedFollowing: []int64{1}, expectedMutuals: []int64{1}},
+		{userId: 3, expectedFollowers: []int64{}, expectedFollowing: []int64{1}, expectedMutuals: []int64{}},
+	}
+
+	for _, test := range testSuites {
+		if exist, _ := router.Followers(test.userId); !reflect.DeepEqual(exist, test.expectedFollowers) {
+			t.Error("failed to get followers of #", test.userId, ". Got ", exist, ", but expected is ", test.expectedFollowers)
+		}
+
+		if exist, _ := router.Following(test.userId); !reflect.DeepEqual(exist, test.expectedFollowing) {
+			t.Error("failed to get followed users of #", test.userId, ". Got ", exist, ", but expected is ", test.expectedFollowing)
+		}
+
+		if exist, _ := router.Mutuals(test.userId); !reflect.DeepEqual(exist, test.expectedMutuals) {
+			t.Error("failed to get mutuals of #", test.userId, ". Got ", exist, ", but expected is ", test.expectedMutuals)
+		}
+
+		followers, following, ok := router.FollowCounts(test.userId)
+		if !ok || followers != len(test.expectedFollowers) || following != len(test.expectedFollowing) {
+			t.Error("failed to count follows of #", test.userId, ". Got ", followers, "/", following, ", but expected is ",
+				len(test.expectedFollowers), "/", len(test.expectedFollowing))
+		}
+	}
+
+	if _, _, ok := router.FollowCounts(1000); ok {
+		t.Error("failed to report an unknown user")
+	}
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"os"
+	"io"
+	"sort"
+	"sync"
+	"time"
+	"bufio"
+	"bytes"
+	"errors"
+	"hash/crc32"
+	"encoding/binary"
+	"github.com/7phs/coding-challenge-queserver/logger"
+)
+
+const (
+	SNAPSHOT_MAGIC = "QSFG3"
+	// snapshots without groups and without blocklists are still read
+	SNAPSHOT_MAGIC_V1 = "QSFG1"
+	SNAPSHOT_MAGIC_V2 = "QSFG2"
+)
+
+var (
+	invalidSnapshotErr = errors.New("invalid snapshot of the follower graph")
+)
+
+// Snapshot is a follower graph, members of groups and blocklists taken under the lock of the router.
+// The sequenceId is the greatest applied one, it is not a cut: messages can be released in the limit mode
+// with gaps. The cut is a list of messages changing the graph applied since the previous snapshot,
+// they are released in the write-ahead log after the snapshot is saved. Messages applied later are
+// replayed from the log after a restart.
+type Snapshot struct {
+	sequenceId int64
+	followers  map[int64][]int64
+	groups     map[int64][]int64
+	blocked    map[int64][]int64
+	// not written to a file
+	applied []int64
+}
+
+func (o *Router) Snapshot() *Snapshot {
+	o.Lock()
+	defer o.Unlock()
+
+	snapshot := &Snapshot{
+		sequenceId: o.lastSequenceId,
+		followers:  make(map[int64][]int64),
+		groups:     make(map[int64][]int64),
+		blocked:    make(map[int64][]int64),
+		applied:    append([]int64{}, o.applied...),
+	}
+
+	o.groups.Range(func(key, value interface{}) bool {
+		if members := value.(*GroupInfo).Members(); len(members) > 0 {
+			snapshot.groups[key.(int64)] = members
+		}
+
+		return true
+	})
+
+	o.rangeUsers(func(userInfo *UserInfo) bool {
+		followers := []int64{}
+		userInfo.Range(func(key, _ interface{}) bool {
+			followers = append(followers, key.(int64))
+
+			return true
+		})
+
+		if len(followers) > 0 {
+			snapshot.followers[userInfo.userId] = followers
+		}
+
+		blocked := []int64{}
+		userInfo.RangeBlocked(func(key, _ interface{}) bool {
+			blocked = append(blocked, key.(int64))
+
+			return true
+		})
+
+		if len(blocked) > 0 {
+			snapshot.blocked[userInfo.userId] = blocked
+		}
+
+		return true
+	})
+
+	return snapshot
+}
+
+// Snapshotted stops tracking messages included into a saved snapshot
+func (o *Router) Snapshotted(snapshot *Snapshot) {
+	o.Lock()
+	defer o.Unlock()
+
+	// messages applied after taking the snapshot are appended to the tail
+	o.applied = append([]int64{}, o.applied[len(snapshot.applied):]...)
+}
+
+func (o *Router) Restore(snapshot *Snapshot) {
+	o.Lock()
+	defer o.Unlock()
+
+	for userId, followers := range snapshot.followers {
+		userInfo := o.getOrAddUserInfo(userId)
+
+		for _, follower := range followers {
+			o.follow(follower, userInfo)
+		}
+	}
+
+	for userId, blocked := range snapshot.blocked {
+		userInfo := o.getOrAddUserInfo(userId)
+
+		for _, blockedId := range blocked {
+			userInfo.Block(blockedId)
+		}
+	}
+
+	for groupId, members := range snapshot.groups {
+		groupInfo := o.getOrAddGroupInfo(groupId)
+
+		for _, member := range members {
+			groupInfo.Join(member)
+			o.getOrAddUserInfo(member).JoinGroup(groupId)
+		}
+	}
+
+	if snapshot.sequenceId > o.lastSequenceId {
+		o.lastSequenceId = snapshot.sequenceId
+	}
+
+	logger.Info("[ROUTER]: restore followers of ", len(snapshot.followers), " users and ", len(snapshot.groups), " groups at #", snapshot.sequenceId)
+}
+
+// The file layout is the magic, varints of the sequenceId, a count of users and for each user
+// its id, a count of followers and their ids, then lists of members of groups and lists of blocked users
+// in the same layout. A crc32 of all previous bytes closes the file.
+func (o *Snapshot) MarshalBinary() ([]byte, error) {
+	var (
+		buf    bytes.Buffer
+		varint = make([]byte, binary.MaxVarintLen64)
+	)
+
+	put := func(v int64) {
+		buf.Write(varint[:binary.PutVarint(varint, v)])
+	}
+
+	putLists := func(lists map[int64][]int64) {
+		keys := make([]int64, 0, len(lists))
+		for key := range lists {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+		put(int64(len(keys)))
+
+		for _, key := range keys {
+			put(key)
+			put(int64(len(lists[key])))
+			for _, v := range lists[key] {
+				put(v)
+			}
+		}
+	}
+
+	buf.WriteString(SNAPSHOT_MAGIC)
+	put(o.sequenceId)
+	putLists(o.followers)
+	putLists(o.groups)
+	putLists(o.blocked)
+
+	binary.Write(&buf, binary.BigEndian, crc32.ChecksumIEEE(buf.Bytes()))
+
+	return buf.Bytes(), nil
+}
+
+func (o *Snapshot) UnmarshalBinary(data []byte) error {
+	if len(data) < len(SNAPSHOT_MAGIC)+4 {
+		return invalidSnapshotErr
+	}
+
+	magic := string(data[:len(SNAPSHOT_MAGIC)])
+	if magic != SNAPSHOT_MAGIC && magic != SNAPSHOT_MAGIC_V2 && magic != SNAPSHOT_MAGIC_V1 {
+		return invalidSnapshotErr
+	}
+
+	body, checksum := data[:len(data)-4], binary.BigEndian.Uint32(data[len(data)-4:])
+	if crc32.ChecksumIEEE(body) != checksum {
+		return invalidSnapshotErr
+	}
+
+	reader := bufio.NewReader(bytes.NewReader(body[len(SNAPSHOT_MAGIC):]))
+
+	var err error
+	get := func() (v int64) {
+		if err == nil {
+			v, err = binary.ReadVarint(reader)
+		}
+
+		return
+	}
+
+	getLists := func() map[int64][]int64 {
+		lists := make(map[int64][]int64)
+
+		for keys := get(); err == nil && keys > 0; keys-- {
+			key := get()
+			count := get()
+
+			list := make([]int64, 0, MaxInt64(0, MinInt64(count, 1024)))
+			for ; err == nil && count > 0; count-- {
+				list = append(list, get())
+			}
+
+			lists[key] = list
+		}
+
+		return lists
+	}
+
+	o.sequenceId = get()
+	o.followers = getLists()
+	o.groups = make(map[int64][]int64)
+	o.blocked = make(map[int64][]int64)
+
+	if magic != SNAPSHOT_MAGIC_V1 {
+		o.groups = getLists()
+	}
+	if magic == SNAPSHOT_MAGIC {
+		o.blocked = getLists()
+	}
+
+	if err == io.EOF {
+		err = invalidSnapshotErr
+	}
+
+	return err
+}
+
+func WriteSnapshot(path string, snapshot *Snapshot) error {
+	data, err := snapshot.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	// replace the previous snapshot atomically to survive a crash while writing
+	tmpPath := path + ".tmp"
+
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	if _, err = file.Write(data); err == nil {
+		err = file.Sync()
+	}
+
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+func ReadSnapshot(path string) (*Snapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := &Snapshot{}
+	if err := snapshot.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+
+	return snapshot, nil
+}
+
+// Snapshotter writes snapshots of the follower graph periodically and on shutdown,
+// messages included into a saved snapshot are released in the write-ahead log
+type Snapshotter struct {
+	path     string
+	interval time.Duration
+	router   *Router
+	wal      *Wal
+
+	shutdown chan struct{}
+	wait     sync.WaitGroup
+}
+
+func NewSnapshotter(config *Config, router *Router, wal *Wal) *Snapshotter {
+	return &Snapshotter{
+		path:     config.SnapshotPath(),
+		interval: config.SnapshotInterval(),
+		router:   router,
+		wal:      wal,
+		shutdown: make(chan struct{}),
+	}
+}
+
+// Load restores the follower graph from the last snapshot if it exists
+func (o *Snapshotter) Load() error {
+	logger.Info("[SNAPSHOT]: load ", o.path)
+
+	snapshot, err := ReadSnapshot(o.path)
+	if os.IsNotExist(err) {
+		logger.Info("[SNAPSHOT]: no snapshot to load")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	o.router.Restore(snapshot)
+
+	return nil
+}
+
+func (o *Snapshotter) Save() {
+	snapshot := o.router.Snapshot()
+
+	if err := WriteSnapshot(o.path, snapshot); err != nil {
+		logger.Error("[SNAPSHOT]: failed to write a snapshot: ", err)
+		return
+	}
+
+	// a crash before releasing replays messages of the snapshot over it once more. Each of them sets a state
+	// of a pair of users, so only a follow refused by a block lifted later can differ.
+	if o.wal != nil {
+		if err := o.wal.Released(snapshot.applied...); err != nil {
+			logger.Error("[SNAPSHOT]: failed to release messages of a snapshot in the write-ahead log: ", err)
+			return
+		}
+	}
+
+	o.router.Snapshotted(snapshot)
+
+	logger.Debug("[SNAPSHOT]: write followers of ", len(snapshot.followers), " users at #", snapshot.sequenceId)
+}
+
+func (o *Snapshotter) Run() {
+	o.wait.Add(1)
+
+	go func() {
+		logger.Info("[SNAPSHOT]: start working goroutin")
+
+		for {
+			select {
+			case <-time.After(o.interval):
+				o.Save()
+
+			case <-o.shutdown:
+				logger.Info("[SNAPSHOT]: shutdown working goroutin")
+				o.wait.Done()
+				return
+			}
+		}
+	}()
+}
+
+func (o *Snapshotter) Shutdown() {
+	logger.Info("[SNAPSHOT]: shutdown")
+
+	close(o.shutdown)
+
+	o.wait.Wait()
+
+	o.Save()
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"os"
+	"sort"
+	"testing"
+	"reflect"
+	"hash/crc32"
+	"path/filepath"
+	"encoding/binary"
+)
+
+func TestSnapshot_Binary(t *testing.T) {
+	snapshot := &Snapshot{
+		sequenceId: 6789,
+		followers: map[int64][]int64{
+			1:   {2, 3, 4},
+			500: {1},
+			-7:  {1000000000},
+		},
+		groups: map[int64][]int64{
+			9: {1, 500},
+		},
+		blocked: map[int64][]int64{
+			500: {-7},
+		},
+	}
+
+	data, err := snapshot.MarshalBinary()
+	if err != nil {
+		t.Error("failed to marshal a snapshot with error: ", err)
+	}
+
+	exist := &Snapshot{}
+	if err := exist.UnmarshalBinary(data); err != nil {
+		t.Error("failed to unmarshal a snapshot with error: ", err)
+	}
+
+	if !reflect.DeepEqual(exist, snapshot) {
+		t.Error("failed to unmarshal a snapshot. Got ", exist, ", but expected is ", snapshot)
+	}
+
+	v1 := &Snapshot{}
+	if err := v1.UnmarshalBinary(snapshotV1(snapshot)); err != nil {
+		t.Error("failed to unmarshal a snapshot without groups with error: ", err)
+	}
+
+	if !reflect.DeepEqual(v1.followers, snapshot.followers) || len(v1.groups) != 0 || len(v1.blocked) != 0 {
+		t.Error("failed to unmarshal a snapshot without groups. Got ", v1, ", but expected are followers ", snapshot.followers)
+	}
+
+	for i, broken := range [][]byte{
+		nil,
+		[]byte("QSFG1"),
+		data[:len(data)-1],
+		append([]byte("XXXXX"), data[5:]...),
+	} {
+		if err := (&Snapshot{}).UnmarshalBinary(broken); err == nil {
+			t.Error(i, ": failed to catch an error of a broken snapshot")
+		}
+	}
+}
+
+func TestRouter_SnapshotRestore(t *testing.T) {
+	router := NewRouter(&Config{clientBuffer: 16}, NewStatistics())
+
+	for _, msg := range []*Message{
+		{sequenceId: 1, typ: MESSAGE_FOLLOW, from: 10, to: 20},
+		{sequenceId: 2, typ: MESSAGE_FOLLOW, from: 11, to: 20},
+		{sequenceId: 3, typ: MESSAGE_FOLLOW, from: 12, to: 21},
+		{sequenceId: 4, typ: MESSAGE_UNFOLLOW, from: 12, to: 21},
+		{sequenceId: 5, typ: MESSAGE_FOLLOW, from: 20, to: 10},
+		{sequenceId: 6, typ: MESSAGE_GROUP_JOIN, from: 10, to: 7},
+		{sequenceId: 7, typ: MESSAGE_GROUP_JOIN, from: 11, to: 7},
+		{sequenceId: 8, typ: MESSAGE_GROUP_JOIN, from: 12, to: 8},
+		{sequenceId: 9, typ: MESSAGE_GROUP_LEAVE, from: 12, to: 8},
+		{sequenceId: 10, typ: MESSAGE_BLOCK, from: 20, to: 12},
+	} {
+		router.PushMessage(msg)
+	}
+
+	path := filepath.Join(t.TempDir(), "graph.snapshot")
+
+	snapshotter := NewSnapshotter(&Config{snapshotPath: path}, router, nil)
+	snapshotter.Shutdown()
+
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Error("failed to clean a temporary file of a snapshot")
+	}
+
+	restored := NewRouter(&Config{clientBuffer: 16}, NewStatistics())
+	if err := NewSnapshotter(&Config{snapshotPath: path}, restored, nil).Load(); err != nil {
+		t.Error("failed to load a snapshot with error: ", err)
+	}
+
+	if restored.lastSequenceId != 10 {
+		t.Error("failed to restore the last sequenceId. Got ", restored.lastSequenceId, ", but expected is ", 10)
+	}
+
+	expected := map[int64][]int64{
+		10: {20},
+		20: {10, 11},
+	}
+
+	exist := restored.Snapshot().followers
+	for _, followers := range exist {
+		sort.Slice(followers, func(i, j int) bool { return followers[i] < followers[j] })
+	}
+
+	if !reflect.DeepEqual(exist, expected) {
+		t.Error("failed to restore followers. Got ", exist, ", but expected is ", expected)
+	}
+
+	expectedGroups := map[int64][]int64{
+		7: {10, 11},
+	}
+
+	if exist := restored.Snapshot().groups; !reflect.DeepEqual(exist, expectedGroups) {
+		t.Error("failed to restore groups. Got ", exist, ", but expected is ", expectedGroups)
+	}
+
+	if _, ok := restored.getOrAddUserInfo(11).groups.Load(int64(7)); !ok {
+		t.Error("failed to restore groups of a user")
+	}
+
+	if !restored.getOrAddUserInfo(20).IsBlocked(12) {
+		t.Error("failed to restore blocked users")
+	}
+}
+
+// snapshotV1 writes followers of a snapshot in the format before groups
+func snapshotV1(snapshot *Snapshot) []byte {
+	data, _ := (&Snapshot{sequenceId: snapshot.sequenceId, followers: snapshot.followers}).MarshalBinary()
+
+	// drop the checksum and empty lists of groups and blocked users
+	body := append([]byte(SNAPSHOT_MAGIC_V1), data[len(SNAPSHOT_MAGIC):len(data)-6]...)
+
+	return binary.BigEndian.AppendUint32(body, crc32.ChecksumIEEE(body))
+}
+
+func TestSnapshotter_LoadMissing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "graph.snapshot")
+
+	if err := NewSnapshotter(&Config{snapshotPath: path}, NewRouter(&Config{clientBuffer: 16}, NewStatistics()), nil).Load(); err != nil {
+		t.Error("failed to skip a missing snapshot with error: ", err)
+	}
+}
+
+func TestSnapshotter_ReplayWal(t *testing.T) {
+	dir := t.TempDir()
+	config := &Config{
+		clientBuffer:   16,
+		walDir:         dir,
+		walSegmentSize: 1024 * 1024,
+		walSync:        WAL_SYNC_ALWAYS,
+		snapshotPath:   filepath.Join(dir, "graph.snapshot"),
+	}
+
+	push := func(router *Router, wal *Wal, payloads ...string) {
+		for _, payload := range payloads {
+			msg := NewMessage(payload)
+
+			wal.Append(msg)
+			router.PushMessage(msg)
+			wal.Delivered(msg.sequenceId)
+		}
+	}
+
+	wal, _ := NewWal(config)
+	router := NewRouter(config, NewStatistics())
+	snapshotter := NewSnapshotter(config, router, wal)
+
+	push(router, wal, "1|F|10|20", "2|GJ|10|7")
+	snapshotter.Save()
+
+	if exist := len(wal.Applied()); exist != 0 {
+		t.Error("failed to release messages of a saved snapshot. Got ", exist, ", but expected is ", 0)
+	}
+
+	// a crash after changes of the graph
+	push(router, wal, "3|F|11|20", "4|S|20", "5|U|10|20")
+	wal.Shutdown()
+
+	wal, _ = NewWal(config)
+	defer wal.Shutdown()
+
+	restored := NewRouter(config, NewStatistics())
+	ch := restored.RegisterClient(20, false)
+
+	if err := NewSnapshotter(config, restored, wal).Load(); err != nil {
+		t.Error("failed to load a snapshot with error: ", err)
+	}
+	restored.Replay(wal.Applied())
+
+	expected := map[int64][]int64{
+		20: {11},
+	}
+
+	if exist := restored.Snapshot().followers; !reflect.DeepEqual(exist, expected) {
+		t.Error("failed to replay changes of the graph after a snapshot. Got ", exist, ", but expected is ", expected)
+	}
+
+	if restored.lastSequenceId != 5 {
+		t.Error("failed to replay the last sequenceId. Got ", restored.lastSequenceId, ", but expected is ", 5)
+	}
+
+	if exist := len(ch); exist != 0 {
+		t.Error("failed to replay messages without sending them. Got ", exist, ", but expected is ", 0)
+	}
+}
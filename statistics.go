@@ -13,16 +13,6 @@ const (
 	STATISTICS_DUMP_INTERVAL = 2 * time.Second
 )
 
-var (
-	STATISITICS_DUMP_INFO = []MessageType{
-		MESSAGE_BROADCAST,
-		MESSAGE_FOLLOW,
-		MESSAGE_STATUS_UPDATE,
-		MESSAGE_UNFOLLOW,
-		MESSAGE_PRIVATE_MSG,
-	}
-)
-
 type Direction int
 
 const (
@@ -30,6 +20,37 @@ const (
 	MESSAGE_SEND
 )
 
+// LatencyStage is a part of a path of a message from an event source to a client
+type LatencyStage int
+
+const (
+	// from receiving by an event source to releasing by the queue
+	LATENCY_QUEUE LatencyStage = iota
+	// from releasing by the queue to routing by the router
+	LATENCY_ROUTE
+	// from routing to writing to a client
+	LATENCY_WRITE
+	// from receiving to writing to a client
+	LATENCY_TOTAL
+
+	LATENCY_UNKNOWN
+)
+
+func (o LatencyStage) String() string {
+	switch o {
+	case LATENCY_QUEUE:
+		return "queue"
+	case LATENCY_ROUTE:
+		return "route"
+	case LATENCY_WRITE:
+		return "write"
+	case LATENCY_TOTAL:
+		return "total"
+	default:
+		return "unknown"
+	}
+}
+
 type Statistics struct {
 	runDumping sync.Once
 
@@ -37,15 +58,51 @@ type Statistics struct {
 	receivedTotal uint64
 	sent          []uint64
 	sentTotal     uint64
+	overflow      []uint64
+	gaps          uint64
+	gapsSkipped   uint64
+	late          uint64
+	duplicates    uint64
+	// messages refused by recipients blocking a sender, by a message type
+	blocked []uint64
+	// latency histograms by a stage and a message type
+	latency [][]*Histogram
+	// recipients and durations of fan-outs by a message type
+	fanoutSize     []*Histogram
+	fanoutDuration []*Histogram
+	// *GroupCounters by a group id
+	groups sync.Map
 
 	shutdown chan struct{}
 	wait     sync.WaitGroup
 }
 
 func NewStatistics() *Statistics {
+	latency := make([][]*Histogram, LATENCY_UNKNOWN)
+	for stage := range latency {
+		latency[stage] = make([]*Histogram, messageTypeLimit())
+		for messageType := range latency[stage] {
+			latency[stage][messageType] = NewHistogram(HISTOGRAM_LATENCY_BUCKETS)
+		}
+	}
+
+	fanoutSize := make([]*Histogram, messageTypeLimit())
+	fanoutDuration := make([]*Histogram, messageTypeLimit())
+	for messageType := range fanoutSize {
+		fanoutSize[messageType] = NewSizeHistogram(HISTOGRAM_FANOUT_BUCKETS)
+		fanoutDuration[messageType] = NewHistogram(HISTOGRAM_LATENCY_BUCKETS)
+	}
+
 	return &Statistics{
-		received: make([]uint64, MESSAGE_UNKNOWN),
-		sent:     make([]uint64, MESSAGE_UNKNOWN),
+		received: make([]uint64, messageTypeLimit()),
+		sent:     make([]uint64, messageTypeLimit()),
+		blocked:  make([]uint64, messageTypeLimit()),
+		overflow: make([]uint64, OVERFLOW_UNKNOWN),
+		latency:  latency,
+
+		fanoutSize:     fanoutSize,
+		fanoutDuration: fanoutDuration,
+
 		shutdown: make(chan struct{}),
 	}
 }
@@ -57,17 +114,195 @@ func (o *Statistics) Add(direction Direction, messageType MessageType) {
 			go o.Working()
 		})
 
+		// messages of an unknown type are counted only in totals
+		known := messageType.Info() != nil
+
 		switch direction {
 		case MESSAGE_RECIEVE:
-			atomic.AddUint64(&o.received[messageType], 1)
+			if known {
+				atomic.AddUint64(&o.received[messageType], 1)
+			}
 			atomic.AddUint64(&o.receivedTotal, 1)
 		case MESSAGE_SEND:
-			atomic.AddUint64(&o.sent[messageType], 1)
+			if known {
+				atomic.AddUint64(&o.sent[messageType], 1)
+			}
 			atomic.AddUint64(&o.sentTotal, 1)
 		}
 	}()
 }
 
+// AddOverflow counts messages handled by an overflow policy of an outbound buffer of a client
+func (o *Statistics) AddOverflow(policy OverflowPolicy) {
+	atomic.AddUint64(&o.overflow[policy], 1)
+}
+
+// AddGap counts a timed out gap of sequenceId skipped by the queue in the strict mode
+func (o *Statistics) AddGap(skipped int64) {
+	atomic.AddUint64(&o.gaps, 1)
+	atomic.AddUint64(&o.gapsSkipped, uint64(skipped))
+}
+
+// AddLate counts a message dropped by the queue after skipping its gap in the strict mode
+func (o *Statistics) AddLate() {
+	atomic.AddUint64(&o.late, 1)
+}
+
+// AddDuplicate counts a message rejected by the queue as a replay of an already accepted sequenceId
+func (o *Statistics) AddDuplicate() {
+	atomic.AddUint64(&o.duplicates, 1)
+}
+
+// AddBlocked counts a message refused by a recipient blocking its sender
+func (o *Statistics) AddBlocked(messageType MessageType) {
+	if messageType.Info() != nil {
+		atomic.AddUint64(&o.blocked[messageType], 1)
+	}
+}
+
+// GroupCounters counts events of a group
+type GroupCounters struct {
+	Joined    uint64 `json:"joined"`
+	Left      uint64 `json:"left"`
+	Messages  uint64 `json:"messages"`
+	Delivered uint64 `json:"delivered"`
+	Rejected  uint64 `json:"rejected"`
+}
+
+func (o *Statistics) groupCounters(groupId int64) *GroupCounters {
+	if counters, ok := o.groups.Load(groupId); ok {
+		return counters.(*GroupCounters)
+	}
+
+	counters, _ := o.groups.LoadOrStore(groupId, &GroupCounters{})
+
+	return counters.(*GroupCounters)
+}
+
+func (o *Statistics) AddGroupJoin(groupId int64) {
+	atomic.AddUint64(&o.groupCounters(groupId).Joined, 1)
+}
+
+func (o *Statistics) AddGroupLeave(groupId int64) {
+	atomic.AddUint64(&o.groupCounters(groupId).Left, 1)
+}
+
+// AddGroupMessage counts a message of a group and its copies sent to members
+func (o *Statistics) AddGroupMessage(groupId int64, delivered int) {
+	counters := o.groupCounters(groupId)
+
+	atomic.AddUint64(&counters.Messages, 1)
+	atomic.AddUint64(&counters.Delivered, uint64(delivered))
+}
+
+// AddGroupRejected counts a message of a group sent by a non-member
+func (o *Statistics) AddGroupRejected(groupId int64) {
+	atomic.AddUint64(&o.groupCounters(groupId).Rejected, 1)
+}
+
+// Groups returns copies of counters by group ids
+func (o *Statistics) Groups() map[int64]GroupCounters {
+	result := make(map[int64]GroupCounters)
+
+	o.groups.Range(func(key, value interface{}) bool {
+		counters := value.(*GroupCounters)
+
+		result[key.(int64)] = GroupCounters{
+			Joined:    atomic.LoadUint64(&counters.Joined),
+			Left:      atomic.LoadUint64(&counters.Left),
+			Messages:  atomic.LoadUint64(&counters.Messages),
+			Delivered: atomic.LoadUint64(&counters.Delivered),
+			Rejected:  atomic.LoadUint64(&counters.Rejected),
+		}
+
+		return true
+	})
+
+	return result
+}
+
+// ObserveLatency counts times of stages of a message written to a client.
+// Stages without timestamps, e.g. of messages bypassing the queue, are skipped.
+func (o *Statistics) ObserveLatency(msg *Message, written time.Time) {
+	if msg.typ.Info() == nil {
+		return
+	}
+
+	observe := func(stage LatencyStage, from, to time.Time) {
+		if !from.IsZero() && !to.IsZero() {
+			o.latency[stage][msg.typ].Observe(to.Sub(from))
+		}
+	}
+
+	observe(LATENCY_QUEUE, msg.created, msg.released)
+	observe(LATENCY_ROUTE, msg.released, msg.routed)
+	observe(LATENCY_WRITE, msg.routed, written)
+	observe(LATENCY_TOTAL, msg.created, written)
+}
+
+// Latency returns a histogram of a stage of messages of a type
+func (o *Statistics) Latency(stage LatencyStage, messageType MessageType) *Histogram {
+	return o.latency[stage][messageType]
+}
+
+// ObserveFanout counts recipients of a message and a time of sending it to all of them
+func (o *Statistics) ObserveFanout(messageType MessageType, recipients int, duration time.Duration) {
+	if messageType.Info() == nil {
+		return
+	}
+
+	o.fanoutSize[messageType].ObserveSize(recipients)
+	o.fanoutDuration[messageType].Observe(duration)
+}
+
+// Fanout returns histograms of recipients and durations of fan-outs of messages of a type
+func (o *Statistics) Fanout(messageType MessageType) (size *Histogram, duration *Histogram) {
+	return o.fanoutSize[messageType], o.fanoutDuration[messageType]
+}
+
+// StatisticsState is a snapshot of counters
+type StatisticsState struct {
+	Received      map[string]uint64       `json:"received"`
+	ReceivedTotal uint64                  `json:"receivedTotal"`
+	Sent          map[string]uint64       `json:"sent"`
+	SentTotal     uint64                  `json:"sentTotal"`
+	Overflow      map[string]uint64       `json:"overflow"`
+	Gaps          uint64                  `json:"gaps"`
+	GapsSkipped   uint64                  `json:"gapsSkipped"`
+	Late          uint64                  `json:"late"`
+	Duplicates    uint64                  `json:"duplicates"`
+	Blocked       map[string]uint64       `json:"blocked"`
+	Groups        map[int64]GroupCounters `json:"groups"`
+}
+
+func (o *Statistics) State() *StatisticsState {
+	state := &StatisticsState{
+		Received:      make(map[string]uint64),
+		ReceivedTotal: atomic.LoadUint64(&o.receivedTotal),
+		Sent:          make(map[string]uint64),
+		SentTotal:     atomic.LoadUint64(&o.sentTotal),
+		Overflow:      make(map[string]uint64),
+		Gaps:          atomic.LoadUint64(&o.gaps),
+		GapsSkipped:   atomic.LoadUint64(&o.gapsSkipped),
+		Late:          atomic.LoadUint64(&o.late),
+		Duplicates:    atomic.LoadUint64(&o.duplicates),
+		Blocked:       make(map[string]uint64),
+		Groups:        o.Groups(),
+	}
+
+	for _, messageType := range MessageTypes() {
+		state.Received[messageType.String()] = atomic.LoadUint64(&o.received[messageType])
+		state.Sent[messageType.String()] = atomic.LoadUint64(&o.sent[messageType])
+		state.Blocked[messageType.String()] = atomic.LoadUint64(&o.blocked[messageType])
+	}
+
+	for _, policy := range []OverflowPolicy{OVERFLOW_DROP_OLDEST, OVERFLOW_DROP_NEWEST, OVERFLOW_DISCONNECT} {
+		state.Overflow[policy.String()] = atomic.LoadUint64(&o.overflow[policy])
+	}
+
+	return state
+}
+
 func (o *Statistics) Working() {
 	logger.Info("[STATISTICS]: start working goroutin")
 
@@ -75,6 +310,14 @@ func (o *Statistics) Working() {
 		select {
 		case <-time.After(STATISTICS_DUMP_INTERVAL):
 			logger.Info("[STATISTICS]: " + o.DumpState())
+
+			if latency := o.DumpLatency(); latency != "" {
+				logger.Info("[STATISTICS]: " + latency)
+			}
+
+			if fanout := o.DumpFanout(); fanout != "" {
+				logger.Info("[STATISTICS]: " + fanout)
+			}
 		case <-o.shutdown:
 			logger.Info("[STATISTICS]: stop working goroutin")
 			o.wait.Done()
@@ -87,7 +330,7 @@ func (o *Statistics) DumpState() string {
 	line := bytes.NewBufferString("Received/sent: ")
 	add := 0
 
-	for _, messageType := range STATISITICS_DUMP_INFO {
+	for _, messageType := range MessageTypes() {
 		received := atomic.LoadUint64(&o.received[messageType])
 		sent := atomic.LoadUint64(&o.sent[messageType])
 
@@ -111,6 +354,96 @@ func (o *Statistics) DumpState() string {
 
 	line.WriteString(fmt.Sprint("total -> ", received, "/", sent))
 
+	droppedOldest := atomic.LoadUint64(&o.overflow[OVERFLOW_DROP_OLDEST])
+	droppedNewest := atomic.LoadUint64(&o.overflow[OVERFLOW_DROP_NEWEST])
+	disconnected := atomic.LoadUint64(&o.overflow[OVERFLOW_DISCONNECT])
+
+	if droppedOldest > 0 || droppedNewest > 0 || disconnected > 0 {
+		line.WriteString(fmt.Sprint("; dropped oldest/newest, disconnected -> ",
+			droppedOldest, "/", droppedNewest, ", ", disconnected))
+	}
+
+	gaps := atomic.LoadUint64(&o.gaps)
+	gapsSkipped := atomic.LoadUint64(&o.gapsSkipped)
+	late := atomic.LoadUint64(&o.late)
+
+	if gaps > 0 || late > 0 {
+		line.WriteString(fmt.Sprint("; gaps/skipped ids, late -> ", gaps, "/", gapsSkipped, ", ", late))
+	}
+
+	if duplicates := atomic.LoadUint64(&o.duplicates); duplicates > 0 {
+		line.WriteString(fmt.Sprint("; duplicates -> ", duplicates))
+	}
+
+	var blocked uint64
+	for _, messageType := range MessageTypes() {
+		blocked += atomic.LoadUint64(&o.blocked[messageType])
+	}
+
+	if blocked > 0 {
+		line.WriteString(fmt.Sprint("; blocked -> ", blocked))
+	}
+
+	if groups := o.Groups(); len(groups) > 0 {
+		var messages, delivered uint64
+		for _, counters := range groups {
+			messages += counters.Messages
+			delivered += counters.Delivered
+		}
+
+		line.WriteString(fmt.Sprint("; groups/messages/delivered -> ", len(groups), "/", messages, "/", delivered))
+	}
+
+	return line.String()
+}
+
+// DumpLatency describes latency of stages by message types as "mean/p99", types without messages are skipped
+func (o *Statistics) DumpLatency() string {
+	line := bytes.NewBuffer(nil)
+
+	for _, messageType := range MessageTypes() {
+		if o.latency[LATENCY_TOTAL][messageType].Count() == 0 {
+			continue
+		}
+
+		if line.Len() == 0 {
+			line.WriteString("Latency mean/p99: ")
+		} else {
+			line.WriteString("; ")
+		}
+
+		line.WriteString(messageType.String() + " ->")
+
+		for stage := LATENCY_QUEUE; stage < LATENCY_UNKNOWN; stage++ {
+			histogram := o.latency[stage][messageType]
+
+			line.WriteString(fmt.Sprint(" ", stage, " ", histogram.Mean(), "/", histogram.Quantile(0.99)))
+		}
+	}
+
+	return line.String()
+}
+
+// DumpFanout describes fan-outs by message types as "recipients mean/p99, duration mean/p99", types without fan-outs are skipped
+func (o *Statistics) DumpFanout() string {
+	line := bytes.NewBuffer(nil)
+
+	for _, messageType := range MessageTypes() {
+		size, duration := o.Fanout(messageType)
+		if size.Count() == 0 {
+			continue
+		}
+
+		if line.Len() == 0 {
+			line.WriteString("Fan-out recipients mean/p99, duration mean/p99: ")
+		} else {
+			line.WriteString("; ")
+		}
+
+		line.WriteString(fmt.Sprint(messageType, " -> ", int64(size.Mean()), "/", int64(size.Quantile(0.99)), ", ",
+			duration.Mean(), "/", duration.Quantile(0.99)))
+	}
+
 	return line.String()
 }
 
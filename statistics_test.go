@@ -22,8 +22,9 @@ func TestNewStatistics(t *testing.T) {
 	// wait for go routing
 	time.Sleep(100 * time.Millisecond)
 
+	// counters of built-in types, types registered by tests are not counted
 	expected := []uint64{0, 2, 2, 2, 2, 2}
-	if !reflect.DeepEqual(statistics.received, expected) {
+	if !reflect.DeepEqual(statistics.received[:len(expected)], expected) {
 		t.Error("failed to calc all recieved items. Got ", statistics.received, ", but expected is ", expected)
 	}
 	if statistics.receivedTotal!=10 {
@@ -31,7 +32,7 @@ func TestNewStatistics(t *testing.T) {
 	}
 
 	expected = []uint64{0, 1, 1, 1, 1, 1}
-	if !reflect.DeepEqual(statistics.sent, expected) {
+	if !reflect.DeepEqual(statistics.sent[:len(expected)], expected) {
 		t.Error("failed to calc all sent items. Got ", statistics.sent, ", but expected is ", expected)
 	}
 	if statistics.sentTotal!=5 {
@@ -44,3 +45,80 @@ func TestNewStatistics(t *testing.T) {
 		t.Error("failed to get state. Got '", exist, "', but expected is '", expectedStr, "'")
 	}
 }
+
+func TestStatistics_AddOverflow(t *testing.T) {
+	statistics := NewStatistics()
+
+	statistics.AddOverflow(OVERFLOW_DROP_OLDEST)
+	statistics.AddOverflow(OVERFLOW_DROP_OLDEST)
+	statistics.AddOverflow(OVERFLOW_DISCONNECT)
+
+	expectedStr := "Received/sent: total -> 0/0; dropped oldest/newest, disconnected -> 2/0, 1"
+	if exist := statistics.DumpState(); exist != expectedStr {
+		t.Error("failed to get state. Got '", exist, "', but expected is '", expectedStr, "'")
+	}
+}
+
+func TestStatistics_ObserveLatency(t *testing.T) {
+	statistics := NewStatistics()
+
+	now := time.Now()
+	msg := &Message{
+		typ:      MESSAGE_PRIVATE_MSG,
+		created:  now.Add(-30 * time.Millisecond),
+		released: now.Add(-10 * time.Millisecond),
+		routed:   now.Add(-8 * time.Millisecond),
+	}
+
+	statistics.ObserveLatency(msg, now)
+	// messages pushed to the router directly have no stage of the queue
+	statistics.ObserveLatency(&Message{typ: MESSAGE_PRIVATE_MSG, routed: now}, now)
+
+	testSuites := []*struct {
+		stage         LatencyStage
+		expectedCount uint64
+		expectedSum   time.Duration
+	}{
+		{stage: LATENCY_QUEUE, expectedCount: 1, expectedSum: 20 * time.Millisecond},
+		{stage: LATENCY_ROUTE, expectedCount: 1, expectedSum: 2 * time.Millisecond},
+		{stage: LATENCY_WRITE, expectedCount: 2, expectedSum: 8 * time.Millisecond},
+		{stage: LATENCY_TOTAL, expectedCount: 1, expectedSum: 30 * time.Millisecond},
+	}
+
+	for _, test := range testSuites {
+		histogram := statistics.Latency(test.stage, MESSAGE_PRIVATE_MSG)
+
+		if exist := histogram.Count(); exist != test.expectedCount {
+			t.Error("failed to count latency of ", test.stage, ". Got ", exist, ", but expected is ", test.expectedCount)
+		}
+		if exist := histogram.Sum(); exist != test.expectedSum {
+			t.Error("failed to sum latency of ", test.stage, ". Got ", exist, ", but expected is ", test.expectedSum)
+		}
+	}
+
+	expected := "Latency mean/p99: Private -> queue 20ms/50ms route 2ms/5ms write 4ms/10ms total 30ms/50ms"
+	if exist := statistics.DumpLatency(); exist != expected {
+		t.Error("failed to dump latency. Got '", exist, "', but expected is '", expected, "'")
+	}
+}
+
+func TestStatistics_ObserveFanout(t *testing.T) {
+	statistics := NewStatistics()
+
+	statistics.ObserveFanout(MESSAGE_STATUS_UPDATE, 5, 2*time.Millisecond)
+	statistics.ObserveFanout(MESSAGE_STATUS_UPDATE, 15, 4*time.Millisecond)
+	statistics.ObserveFanout(MESSAGE_UNKNOWN, 15, 4*time.Millisecond)
+
+	size, duration := statistics.Fanout(MESSAGE_STATUS_UPDATE)
+	if exist := size.Sum(); exist != 20 {
+		t.Error("failed to sum recipients. Got ", int64(exist), ", but expected is ", 20)
+	}
+	if exist := duration.Sum(); exist != 6*time.Millisecond {
+		t.Error("failed to sum durations. Got ", exist, ", but expected is ", 6*time.Millisecond)
+	}
+
+	expected := "Fan-out recipients mean/p99, duration mean/p99: StatusUpdate -> 10/100, 3ms/5ms"
+	if exist := statistics.DumpFanout(); exist != expected {
+		t.Error("failed to dump fan-outs. Got '", exist, "', but expected is '", expected, "'")
+	}
+}
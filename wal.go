@@ -0,0 +1,590 @@
+package main
+
+import (
+	"os"
+	"io"
+	"fmt"
+	"sync"
+	"sort"
+	"time"
+	"bufio"
+	"bytes"
+	"errors"
+	"strconv"
+	"strings"
+	"hash/crc32"
+	"path/filepath"
+	"github.com/7phs/coding-challenge-queserver/logger"
+)
+
+type WalSyncPolicy int
+
+const (
+	WAL_SYNC_ALWAYS   WalSyncPolicy = iota + 1
+	WAL_SYNC_INTERVAL
+	WAL_SYNC_NEVER
+)
+
+func (o WalSyncPolicy) String() string {
+	switch o {
+	case WAL_SYNC_ALWAYS:
+		return "Always"
+	case WAL_SYNC_INTERVAL:
+		return "Interval"
+	case WAL_SYNC_NEVER:
+		return "Never"
+	default:
+		return "Unknown"
+	}
+}
+
+func ParseWalSyncPolicy(policy string, defaultPolicy WalSyncPolicy) WalSyncPolicy {
+	switch strings.ToLower(policy) {
+	case "always":
+		return WAL_SYNC_ALWAYS
+	case "interval":
+		return WAL_SYNC_INTERVAL
+	case "never":
+		return WAL_SYNC_NEVER
+	default:
+		return defaultPolicy
+	}
+}
+
+const (
+	WAL_SEGMENT_EXT = ".wal"
+
+	WAL_RECORD_EVENT      = 'E'
+	WAL_RECORD_DELIVERED  = 'D'
+	WAL_RECORD_CHECKPOINT = 'C'
+	WAL_RECORD_RELEASED   = 'R'
+)
+
+var (
+	invalidWalRecordErr = errors.New("invalid write-ahead log record")
+)
+
+// A record is a line: <type>|<crc32 of data in hex>|<data>.
+// An event record stores a raw payload of a message,
+// a delivered record stores a comma-separated list of sequenceId pulled to the router,
+// a released record stores a list of sequenceId not needed for a replay anymore,
+// a checkpoint record starting each segment stores the last delivered sequenceId of removed segments.
+func encodeWalRecord(typ byte, data string) []byte {
+	return []byte(fmt.Sprintf("%c|%08x|%s\n", typ, crc32.ChecksumIEEE([]byte(data)), data))
+}
+
+func decodeWalRecord(line []byte) (byte, string, error) {
+	if len(line) < 11 || line[1] != '|' || line[10] != '|' {
+		return 0, "", invalidWalRecordErr
+	}
+
+	checksum, err := strconv.ParseUint(string(line[2:10]), 16, 32)
+	if err != nil {
+		return 0, "", invalidWalRecordErr
+	}
+
+	data := line[11:]
+	if crc32.ChecksumIEEE(data) != uint32(checksum) {
+		return 0, "", invalidWalRecordErr
+	}
+
+	return line[0], string(data), nil
+}
+
+type walEntry struct {
+	payload string
+	segment int64
+	state   bool
+	// an order of applying a delivered message to the router
+	applied int64
+}
+
+// Wal is an append-only log of received messages split into segments.
+// A message is written before it is pushed into the queue and it is marked as delivered
+// after the queue pulls it to the router. With snapshots of the follower graph a delivered message
+// changing the graph is kept until a saved snapshot includes it and releases it.
+// Segments are removed from the oldest one as soon as all of their messages are delivered or released.
+type Wal struct {
+	sync.Mutex
+
+	dir          string
+	segmentSize  int64
+	syncPolicy   WalSyncPolicy
+	syncInterval time.Duration
+
+	segment        *os.File
+	segmentIndex   int64
+	segmentWritten int64
+	dirty          bool
+
+	pending map[int64]*walEntry
+	// delivered messages changing the follower graph, they are kept only with snapshots
+	applied      map[int64]*walEntry
+	appliedOrder int64
+	keepApplied  bool
+	// undelivered and unreleased messages of segments
+	segmentsPending map[int64]int
+	segments        []int64
+	// the greatest delivered sequenceId, it survives removing of delivered segments by checkpoints
+	lastDelivered int64
+
+	shutdown chan struct{}
+	wait     sync.WaitGroup
+}
+
+func NewWal(config *Config) (*Wal, error) {
+	return (&Wal{
+		dir:          config.WalDir(),
+		segmentSize:  config.WalSegmentSize(),
+		syncPolicy:   config.WalSync(),
+		syncInterval: config.WalSyncInterval(),
+
+		pending:         make(map[int64]*walEntry),
+		applied:         make(map[int64]*walEntry),
+		keepApplied:     config.SnapshotPath() != "",
+		segmentsPending: make(map[int64]int),
+
+		shutdown: make(chan struct{}),
+	}).Open()
+}
+
+func (o *Wal) Open() (*Wal, error) {
+	logger.Info("[WAL]: open ", o.dir)
+
+	if err := os.MkdirAll(o.dir, 0755); err != nil {
+		return nil, err
+	}
+
+	segments, err := o.listSegments()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, index := range segments {
+		if err := o.readSegment(index, i == len(segments)-1); err != nil {
+			return nil, err
+		}
+
+		o.segments = append(o.segments, index)
+		o.segmentIndex = index
+	}
+
+	if err := o.openSegment(o.segmentIndex + 1); err != nil {
+		return nil, err
+	}
+
+	o.truncate()
+
+	logger.Info("[WAL]: found ", len(o.pending), " undelivered and ", len(o.applied), " unreleased messages in ", len(segments), " segments")
+
+	return o, nil
+}
+
+func (o *Wal) segmentPath(index int64) string {
+	return filepath.Join(o.dir, fmt.Sprintf("%016d%s", index, WAL_SEGMENT_EXT))
+}
+
+func (o *Wal) listSegments() ([]int64, error) {
+	files, err := os.ReadDir(o.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []int64{}
+
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || !strings.HasSuffix(name, WAL_SEGMENT_EXT) {
+			continue
+		}
+
+		index, err := strconv.ParseInt(strings.TrimSuffix(name, WAL_SEGMENT_EXT), 10, 64)
+		if err != nil {
+			logger.Warning("[WAL]: skip unknown file ", name)
+			continue
+		}
+
+		result = append(result, index)
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+
+	return result, nil
+}
+
+func (o *Wal) readSegment(index int64, last bool) error {
+	file, err := os.OpenFile(o.segmentPath(index), os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	o.segmentsPending[index] = 0
+
+	var (
+		reader = bufio.NewReader(file)
+		offset int64
+	)
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF && len(line) == 0 {
+			return nil
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		typ, data, recordErr := byte(0), "", invalidWalRecordErr
+		if line[len(line)-1] == '\n' {
+			typ, data, recordErr = decodeWalRecord(bytes.TrimSuffix(line, []byte{'\n'}))
+		}
+
+		if recordErr != nil {
+			if last {
+				// a torn tail of the last segment is left by a crash in the middle of writing
+				logger.Warning("[WAL]: truncate a torn record of segment #", index, " at ", offset)
+
+				return file.Truncate(offset)
+			}
+
+			logger.Warning("[WAL]: skip a broken record of segment #", index, " at ", offset)
+		} else {
+			o.applyRecord(index, typ, data)
+		}
+
+		offset += int64(len(line))
+	}
+}
+
+func (o *Wal) applyRecord(index int64, typ byte, data string) {
+	switch typ {
+	case WAL_RECORD_EVENT:
+		msg := NewMessage(data)
+		o.addPending(msg.sequenceId, data, index, msg.typ.IsState())
+
+	case WAL_RECORD_DELIVERED, WAL_RECORD_RELEASED:
+		for _, part := range strings.Split(data, ",") {
+			sequenceId, err := strconv.ParseInt(part, 10, 64)
+			if err != nil {
+				continue
+			}
+
+			if typ == WAL_RECORD_DELIVERED {
+				o.deliver(sequenceId)
+			} else {
+				o.release(sequenceId)
+			}
+			o.lastDelivered = MaxInt64(o.lastDelivered, sequenceId)
+		}
+
+	case WAL_RECORD_CHECKPOINT:
+		if sequenceId, err := strconv.ParseInt(data, 10, 64); err == nil {
+			o.lastDelivered = MaxInt64(o.lastDelivered, sequenceId)
+		}
+	}
+}
+
+func (o *Wal) addPending(sequenceId int64, payload string, index int64, state bool) {
+	o.removePending(sequenceId)
+
+	o.pending[sequenceId] = &walEntry{
+		payload: payload,
+		segment: index,
+		state:   state,
+	}
+	o.segmentsPending[index]++
+}
+
+func (o *Wal) removePending(sequenceId int64) {
+	if entry, ok := o.pending[sequenceId]; ok {
+		delete(o.pending, sequenceId)
+		o.segmentsPending[entry.segment]--
+	}
+}
+
+// deliver keeps a delivered message changing the follower graph until it is released
+func (o *Wal) deliver(sequenceId int64) {
+	entry, ok := o.pending[sequenceId]
+	if !ok || !entry.state || !o.keepApplied {
+		o.removePending(sequenceId)
+		return
+	}
+
+	delete(o.pending, sequenceId)
+
+	o.appliedOrder++
+	entry.applied = o.appliedOrder
+	o.applied[sequenceId] = entry
+}
+
+// release removes an undelivered or a kept message
+func (o *Wal) release(sequenceId int64) {
+	o.removePending(sequenceId)
+
+	if entry, ok := o.applied[sequenceId]; ok {
+		delete(o.applied, sequenceId)
+		o.segmentsPending[entry.segment]--
+	}
+}
+
+func (o *Wal) openSegment(index int64) (err error) {
+	o.segment, err = os.OpenFile(o.segmentPath(index), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return
+	}
+
+	o.segmentIndex = index
+	o.segmentWritten = 0
+	o.segments = append(o.segments, index)
+	o.segmentsPending[index] = 0
+
+	if o.lastDelivered == 0 {
+		return
+	}
+
+	// older segments can be removed, a new one keeps the last delivered sequenceId.
+	// It is written without rotating, so a small segment still fits it.
+	record := encodeWalRecord(WAL_RECORD_CHECKPOINT, strconv.FormatInt(o.lastDelivered, 10))
+	if _, err = o.segment.Write(record); err != nil {
+		return
+	}
+
+	o.segmentWritten += int64(len(record))
+	o.dirty = true
+
+	return
+}
+
+func (o *Wal) rotate() error {
+	logger.Debug("[WAL]: rotate segment #", o.segmentIndex)
+
+	if err := o.segment.Sync(); err != nil {
+		return err
+	}
+
+	if err := o.segment.Close(); err != nil {
+		return err
+	}
+
+	o.dirty = false
+
+	return o.openSegment(o.segmentIndex + 1)
+}
+
+// remove the oldest segments without undelivered and unreleased messages, but not a current one
+func (o *Wal) truncate() {
+	for len(o.segments) > 1 && o.segmentsPending[o.segments[0]] == 0 {
+		index := o.segments[0]
+
+		logger.Debug("[WAL]: remove delivered segment #", index)
+
+		if err := os.Remove(o.segmentPath(index)); err != nil {
+			logger.Warning("[WAL]: failed to remove segment #", index, ": ", err)
+			return
+		}
+
+		delete(o.segmentsPending, index)
+		o.segments = o.segments[1:]
+	}
+}
+
+func (o *Wal) write(typ byte, data string) error {
+	record := encodeWalRecord(typ, data)
+
+	if _, err := o.segment.Write(record); err != nil {
+		return err
+	}
+
+	o.segmentWritten += int64(len(record))
+	o.dirty = true
+
+	if o.syncPolicy == WAL_SYNC_ALWAYS {
+		if err := o.segment.Sync(); err != nil {
+			return err
+		}
+
+		o.dirty = false
+	}
+
+	if o.segmentWritten >= o.segmentSize {
+		return o.rotate()
+	}
+
+	return nil
+}
+
+// Pending returns undelivered messages found while opening the log ordered by sequenceId
+func (o *Wal) Pending() []*Message {
+	o.Lock()
+	defer o.Unlock()
+
+	result := make([]*Message, 0, len(o.pending))
+
+	for _, entry := range o.pending {
+		result = append(result, NewMessage(entry.payload))
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].sequenceId < result[j].sequenceId })
+
+	return result
+}
+
+// Applied returns delivered messages changing the follower graph which are not released by a snapshot yet,
+// ordered as they were applied to the router
+func (o *Wal) Applied() []*Message {
+	o.Lock()
+	defer o.Unlock()
+
+	entries := make([]*walEntry, 0, len(o.applied))
+	for _, entry := range o.applied {
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].applied < entries[j].applied })
+
+	result := make([]*Message, 0, len(entries))
+	for _, entry := range entries {
+		result = append(result, NewMessage(entry.payload))
+	}
+
+	return result
+}
+
+// LastDelivered returns the greatest sequenceId pulled to the router before a restart, 0 for an empty log
+func (o *Wal) LastDelivered() int64 {
+	o.Lock()
+	defer o.Unlock()
+
+	return o.lastDelivered
+}
+
+func (o *Wal) Append(msg *Message) error {
+	o.Lock()
+	defer o.Unlock()
+
+	index := o.segmentIndex
+
+	if err := o.write(WAL_RECORD_EVENT, msg.payload); err != nil {
+		return err
+	}
+
+	o.addPending(msg.sequenceId, msg.payload, index, msg.typ.IsState())
+
+	return nil
+}
+
+func (o *Wal) Delivered(sequencesId ...int64) error {
+	if len(sequencesId) == 0 {
+		return nil
+	}
+
+	o.Lock()
+	defer o.Unlock()
+
+	parts := make([]string, 0, len(sequencesId))
+	for _, sequenceId := range sequencesId {
+		parts = append(parts, strconv.FormatInt(sequenceId, 10))
+
+		// a segment rotated by the record starts by a checkpoint including it
+		o.lastDelivered = MaxInt64(o.lastDelivered, sequenceId)
+	}
+
+	if err := o.write(WAL_RECORD_DELIVERED, strings.Join(parts, ",")); err != nil {
+		return err
+	}
+
+	for _, sequenceId := range sequencesId {
+		o.deliver(sequenceId)
+	}
+
+	o.truncate()
+
+	return nil
+}
+
+// Released marks messages which are not replayed anymore: messages included into a saved snapshot
+// and dropped messages
+func (o *Wal) Released(sequencesId ...int64) error {
+	if len(sequencesId) == 0 {
+		return nil
+	}
+
+	o.Lock()
+	defer o.Unlock()
+
+	parts := make([]string, 0, len(sequencesId))
+	for _, sequenceId := range sequencesId {
+		parts = append(parts, strconv.FormatInt(sequenceId, 10))
+
+		o.lastDelivered = MaxInt64(o.lastDelivered, sequenceId)
+	}
+
+	if err := o.write(WAL_RECORD_RELEASED, strings.Join(parts, ",")); err != nil {
+		return err
+	}
+
+	for _, sequenceId := range sequencesId {
+		o.release(sequenceId)
+	}
+
+	o.truncate()
+
+	return nil
+}
+
+func (o *Wal) Sync() error {
+	o.Lock()
+	defer o.Unlock()
+
+	if !o.dirty {
+		return nil
+	}
+
+	o.dirty = false
+
+	return o.segment.Sync()
+}
+
+func (o *Wal) Run() {
+	if o.syncPolicy != WAL_SYNC_INTERVAL {
+		return
+	}
+
+	o.wait.Add(1)
+
+	go func() {
+		logger.Info("[WAL]: start working goroutin")
+
+		for {
+			select {
+			case <-time.After(o.syncInterval):
+				if err := o.Sync(); err != nil {
+					logger.Error("[WAL]: failed to sync segment: ", err)
+				}
+
+			case <-o.shutdown:
+				logger.Info("[WAL]: shutdown working goroutin")
+				o.wait.Done()
+				return
+			}
+		}
+	}()
+}
+
+func (o *Wal) Shutdown() {
+	logger.Info("[WAL]: shutdown")
+
+	close(o.shutdown)
+
+	o.wait.Wait()
+
+	o.Lock()
+	defer o.Unlock()
+
+	if err := o.segment.Sync(); err != nil {
+		logger.Error("[WAL]: failed to sync segment: ", err)
+	}
+
+	o.segment.Close()
+}
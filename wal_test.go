@@ -0,0 +1,315 @@
+package main
+
+import (
+	"os"
+	"container/heap"
+	"sync/atomic"
+	"testing"
+	"reflect"
+	"path/filepath"
+)
+
+func NewTestWal(t *testing.T, dir string, segmentSize int64) *Wal {
+	wal, err := NewWal(&Config{
+		walDir:         dir,
+		walSegmentSize: segmentSize,
+		walSync:        WAL_SYNC_ALWAYS,
+	})
+	if err != nil {
+		t.Fatal("failed to open a write-ahead log with error: ", err)
+	}
+
+	return wal
+}
+
+func pendingSequencesId(wal *Wal) []int64 {
+	result := []int64{}
+	for _, msg := range wal.Pending() {
+		result = append(result, msg.sequenceId)
+	}
+
+	return result
+}
+
+func TestParseWalSyncPolicy(t *testing.T) {
+	testSuites := []*struct {
+		in       string
+		expected WalSyncPolicy
+	}{
+		{in: "Always", expected: WAL_SYNC_ALWAYS},
+		{in: "interval", expected: WAL_SYNC_INTERVAL},
+		{in: "NEVER", expected: WAL_SYNC_NEVER},
+		{in: "unknown", expected: WAL_SYNC_INTERVAL},
+		{expected: WAL_SYNC_INTERVAL},
+	}
+
+	for _, test := range testSuites {
+		if exist := ParseWalSyncPolicy(test.in, WAL_SYNC_INTERVAL); exist != test.expected {
+			t.Error("failed to parse sync policy '", test.in, "'. Got ", exist, ", but expected is ", test.expected)
+		}
+	}
+}
+
+func TestWalRecord(t *testing.T) {
+	record := encodeWalRecord(WAL_RECORD_EVENT, "666|F|60|50")
+
+	typ, data, err := decodeWalRecord(record[:len(record)-1])
+	if err != nil {
+		t.Error("failed to decode a record with error: ", err)
+	}
+
+	if typ != WAL_RECORD_EVENT || data != "666|F|60|50" {
+		t.Error("failed to decode a record. Got ", typ, " and '", data, "'")
+	}
+
+	record[len(record)-2] = '1'
+	if _, _, err := decodeWalRecord(record[:len(record)-1]); err == nil {
+		t.Error("failed to catch a checksum error")
+	}
+
+	if _, _, err := decodeWalRecord([]byte("E|123")); err == nil {
+		t.Error("failed to catch a short record error")
+	}
+}
+
+func TestWal_Replay(t *testing.T) {
+	dir := t.TempDir()
+
+	wal := NewTestWal(t, dir, 1024*1024)
+
+	for _, payload := range []string{"5|B", "3|P|1|2", "1|F|1|2", "4|S|1", "2|U|1|2"} {
+		if err := wal.Append(NewMessage(payload)); err != nil {
+			t.Error("failed to append a message with error: ", err)
+		}
+	}
+
+	wal.Delivered(1, 2)
+	wal.Shutdown()
+
+	wal = NewTestWal(t, dir, 1024*1024)
+	defer wal.Shutdown()
+
+	expected := []int64{3, 4, 5}
+	if exist := pendingSequencesId(wal); !reflect.DeepEqual(exist, expected) {
+		t.Error("failed to replay undelivered messages. Got ", exist, ", but expected is ", expected)
+	}
+
+	testQueue := TestQueue{}
+	queue := NewQueue(&Config{
+		queueTTL:   0,
+		queueLimit: 1000,
+	}, &testQueue, NewStatistics()).Replay(wal)
+
+	queue.pullByTTL()
+
+	if !reflect.DeepEqual(testQueue.SequencesId(), expected) {
+		t.Error("failed to pull replayed messages in order. Got ", testQueue.SequencesId(), ", but expected is ", expected)
+	}
+
+	if exist := pendingSequencesId(wal); len(exist) != 0 {
+		t.Error("failed to mark pulled messages as delivered. Got ", exist)
+	}
+}
+
+func TestWal_Segments(t *testing.T) {
+	dir := t.TempDir()
+
+	// each record is rotated into a new segment
+	wal := NewTestWal(t, dir, 1)
+
+	for _, payload := range []string{"1|B", "2|B", "3|B"} {
+		wal.Append(NewMessage(payload))
+	}
+
+	countSegments := func() int {
+		files, _ := filepath.Glob(filepath.Join(dir, "*"+WAL_SEGMENT_EXT))
+		return len(files)
+	}
+
+	if exist := countSegments(); exist != 4 {
+		t.Error("failed to rotate segments. Got ", exist, ", but expected is ", 4)
+	}
+
+	// the oldest segment is still in use
+	wal.Delivered(2)
+	if exist := countSegments(); exist != 5 {
+		t.Error("failed to keep segments with undelivered messages. Got ", exist, ", but expected is ", 5)
+	}
+
+	wal.Delivered(1, 3)
+	if exist := countSegments(); exist != 1 {
+		t.Error("failed to remove delivered segments. Got ", exist, ", but expected is ", 1)
+	}
+
+	wal.Shutdown()
+}
+
+func TestWal_TornTail(t *testing.T) {
+	dir := t.TempDir()
+
+	wal := NewTestWal(t, dir, 1024*1024)
+	wal.Append(NewMessage("1|B"))
+	wal.Append(NewMessage("2|B"))
+	path := wal.segmentPath(wal.segmentIndex)
+	wal.Shutdown()
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal("failed to open a segment with error: ", err)
+	}
+	file.Write([]byte("E|0000"))
+	file.Close()
+
+	wal = NewTestWal(t, dir, 1024*1024)
+	defer wal.Shutdown()
+
+	expected := []int64{1, 2}
+	if exist := pendingSequencesId(wal); !reflect.DeepEqual(exist, expected) {
+		t.Error("failed to replay messages before a torn record. Got ", exist, ", but expected is ", expected)
+	}
+}
+
+func TestQueue_AcceptStoreError(t *testing.T) {
+	wal := NewTestWal(t, t.TempDir(), 1024*1024)
+
+	testQueue := TestQueue{}
+	queue := NewQueue(&Config{
+		queueLimit:  1000,
+		dedupWindow: 100,
+	}, &testQueue, NewStatistics()).Replay(wal)
+
+	// a closed segment fails writes
+	wal.segment.Close()
+
+	msg := NewMessage("1|B")
+	if exist := queue.Accept(msg); exist != storeMessageErr {
+		t.Error("failed to report a failed write. Got ", exist, ", but expected is ", storeMessageErr)
+	}
+
+	if exist := queue.Accept(msg); exist != storeMessageErr {
+		t.Error("failed to accept a retry of a message which is not stored. Got ", exist, ", but expected is ", storeMessageErr)
+	}
+
+	if exist := queue.queue.Len(); exist != 0 {
+		t.Error("failed to skip a message which is not stored. Got ", exist, ", but expected is ", 0)
+	}
+}
+
+func TestWal_LastDelivered(t *testing.T) {
+	dir := t.TempDir()
+
+	// each record is rotated into a new segment, so delivered segments are removed
+	wal := NewTestWal(t, dir, 1)
+
+	for _, payload := range []string{"1|B", "2|B", "3|B"} {
+		wal.Append(NewMessage(payload))
+	}
+
+	wal.Delivered(1, 2, 3)
+	wal.Shutdown()
+
+	wal = NewTestWal(t, dir, 1)
+	defer wal.Shutdown()
+
+	if exist := wal.LastDelivered(); exist != 3 {
+		t.Error("failed to keep the last delivered sequenceId. Got ", exist, ", but expected is ", 3)
+	}
+
+	queue := NewQueue(&Config{
+		queueLimit: 1000,
+		queueMode:  QUEUE_MODE_STRICT,
+	}, &TestQueue{}, NewStatistics()).Replay(wal)
+
+	if queue.nextId != 4 {
+		t.Error("failed to continue after the last delivered message. Got ", queue.nextId, ", but expected is ", 4)
+	}
+}
+
+func TestQueue_DropLateStored(t *testing.T) {
+	wal := NewTestWal(t, t.TempDir(), 1024*1024)
+	defer wal.Shutdown()
+
+	statistics := NewStatistics()
+	queue := NewQueue(&Config{
+		queueMode:       QUEUE_MODE_STRICT,
+		queueGapTimeout: 1000,
+	}, &TestQueue{}, statistics).Replay(wal)
+
+	// a message pushed right after its sequenceId is released
+	msg := NewMessage("3|B")
+	wal.Append(msg)
+	heap.Push(&queue.queue, msg)
+	queue.nextId = 5
+
+	queue.pullStrict()
+
+	if exist := atomic.LoadUint64(&statistics.late); exist != 1 {
+		t.Error("failed to count a late message. Got ", exist, ", but expected is ", 1)
+	}
+
+	if exist := pendingSequencesId(wal); len(exist) != 0 {
+		t.Error("failed to mark a late message as delivered. Got ", exist)
+	}
+}
+
+func TestWal_Applied(t *testing.T) {
+	dir := t.TempDir()
+	config := &Config{
+		walDir:         dir,
+		walSegmentSize: 1,
+		walSync:        WAL_SYNC_ALWAYS,
+		snapshotPath:   filepath.Join(dir, "graph.snapshot"),
+	}
+
+	appliedSequencesId := func(wal *Wal) []int64 {
+		result := []int64{}
+		for _, msg := range wal.Applied() {
+			result = append(result, msg.sequenceId)
+		}
+
+		return result
+	}
+
+	wal, _ := NewWal(config)
+	for _, payload := range []string{"1|F|1|2", "2|B", "3|U|1|2", "4|BL|2|3"} {
+		wal.Append(NewMessage(payload))
+	}
+
+	wal.Delivered(1, 2)
+	wal.Delivered(4, 3)
+	wal.Shutdown()
+
+	wal, _ = NewWal(config)
+
+	expected := []int64{1, 4, 3}
+	if exist := appliedSequencesId(wal); !reflect.DeepEqual(exist, expected) {
+		t.Error("failed to keep applied messages changing the graph in order. Got ", exist, ", but expected is ", expected)
+	}
+
+	countSegments := func() int {
+		files, _ := filepath.Glob(filepath.Join(dir, "*"+WAL_SEGMENT_EXT))
+		return len(files)
+	}
+
+	segments := countSegments()
+
+	// segments of the first two messages are removed, the released record rotates into a new one
+	wal.Released(1, 4)
+	if exist := countSegments(); exist != segments-1 {
+		t.Error("failed to remove released segments. Got ", exist, ", but expected is ", segments-1)
+	}
+
+	wal.Shutdown()
+
+	wal, _ = NewWal(config)
+	defer wal.Shutdown()
+
+	expected = []int64{3}
+	if exist := appliedSequencesId(wal); !reflect.DeepEqual(exist, expected) {
+		t.Error("failed to skip released messages. Got ", exist, ", but expected is ", expected)
+	}
+
+	if exist := len(wal.Pending()); exist != 0 {
+		t.Error("failed to skip applied messages for the queue. Got ", exist, ", but expected is ", 0)
+	}
+}